@@ -0,0 +1,311 @@
+// Package ast declares the types used to represent the syntax tree of a HAML template as
+// returned by gohaml.Parse. The tree is meant for tools like linters, formatters and editors;
+// the engine never modifies it.
+package ast
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Pos is the position of a node in the template. Line and Column are 1-based; Column counts
+// bytes.
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (self Pos) String() string {
+	return fmt.Sprintf("%d:%d", self.Line, self.Column)
+}
+
+// Node is implemented by every node of the tree.
+type Node interface {
+	Pos() Pos
+}
+
+// Expr is implemented by the expressions found in scripts, attributes and code lines.
+type Expr interface {
+	exprNode()
+}
+
+// Lit is a literal value: a string, an int, a float64, a rune, a bool or nil.
+type Lit struct {
+	Value interface{}
+}
+
+// SliceLit is a slice literal, "[X, Y]".
+type SliceLit struct {
+	Elems []Expr
+}
+
+// MapLit is a map literal, "{K: X, L: Y}", whose i-th entry maps Keys[i] to Values[i].
+type MapLit struct {
+	Keys   []Expr
+	Values []Expr
+}
+
+// Path looks a value up in the scope. The first name is a variable; every following name is a
+// struct field or a map key of the value before it.
+type Path struct {
+	Names []string
+}
+
+// Pipe passes the value of X through the helpers of Stages in turn, "X | name arg | name".
+type Pipe struct {
+	X      Expr
+	Stages []*Stage
+}
+
+// Stage is a stage of a pipe, which calls the helper Name with the value before it followed by
+// Args.
+type Stage struct {
+	Name string
+	Args []Expr
+}
+
+func (self *Path) String() string {
+	return strings.Join(self.Names, ".")
+}
+
+func (self *SliceLit) String() string {
+	elems := make([]string, len(self.Elems))
+	for i, x := range self.Elems {
+		elems[i] = exprString(x)
+	}
+	return "[" + strings.Join(elems, ", ") + "]"
+}
+
+func (self *MapLit) String() string {
+	entries := make([]string, len(self.Keys))
+	for i, k := range self.Keys {
+		entries[i] = exprString(k) + ": " + exprString(self.Values[i])
+	}
+	return "{" + strings.Join(entries, ", ") + "}"
+}
+
+func (self *Pipe) String() string {
+	s := exprString(self.X)
+	for _, stage := range self.Stages {
+		s += " | " + stage.Name
+		for _, arg := range stage.Args {
+			s += " " + exprString(arg)
+		}
+	}
+	return s
+}
+
+// exprString returns x the way it is written in a template.
+func exprString(x Expr) string {
+	if lit, ok := x.(*Lit); ok {
+		switch v := lit.Value.(type) {
+		case string:
+			return fmt.Sprintf("%q", v)
+		case rune:
+			return fmt.Sprintf("%q", v)
+		case nil:
+			return "nil"
+		}
+		return fmt.Sprint(lit.Value)
+	}
+	return fmt.Sprint(x)
+}
+
+func (*Lit) exprNode()      {}
+func (*Path) exprNode()     {}
+func (*SliceLit) exprNode() {}
+func (*MapLit) exprNode()   {}
+func (*Pipe) exprNode()     {}
+
+// Attr is an attribute of a tag. Ids and classes given with the '#' and '.' shorthands are
+// attributes with literal keys and values.
+type Attr struct {
+	Key   Expr
+	Value Expr
+}
+
+// File is the root of the tree.
+type File struct {
+	Nodes []Node
+}
+
+// Doctype is a "!!!" line. Type holds the text that follows the exclamation marks, like
+// "Strict" or "5".
+type Doctype struct {
+	Position Pos
+	Type     string
+}
+
+// Tag is an element introduced by '%', '#' or '.'. Name is empty when the tag was given with
+// the '#' or '.' shorthand only and renders as a div. Inline is the *Text or *Script that
+// follows the tag on its line, or nil.
+type Tag struct {
+	Position    Pos
+	Name        string
+	Attrs       []*Attr
+	Inline      Node
+	NoNewline   bool
+	SelfClosing bool
+	Children    []Node
+}
+
+// Text is a line of plain text, or the text following a tag.
+type Text struct {
+	Position  Pos
+	Text      string
+	NoNewline bool
+}
+
+// Script is a "=" line whose value is looked up in the scope, or the script following a tag. X
+// is a *Path unless the script has a pipe.
+type Script struct {
+	Position  Pos
+	X         Expr
+	NoNewline bool
+}
+
+// Range is a "- for Key, Value := range X" line, or a "- for Key := range X" line whose Value is
+// empty. Either name may be "_". Body holds the nodes nested beneath it, and Else the "- else"
+// line that follows them, if any.
+type Range struct {
+	Position Pos
+	Key      string
+	Value    string
+	X        Expr
+	Body     []Node
+	Else     *Else
+}
+
+// Else is a "- else" line that follows the body of a range. Body holds the nodes nested beneath
+// it, which are rendered when there is nothing to range over.
+type Else struct {
+	Position Pos
+	Body     []Node
+}
+
+// Break is a "- break" line, which ends the range whose body it is part of.
+type Break struct {
+	Position Pos
+}
+
+// Continue is a "- continue" line, which skips the rest of the body of the range it is part of.
+type Continue struct {
+	Position Pos
+}
+
+// Switch is a "- switch X" line. Cases holds the cases nested beneath it.
+type Switch struct {
+	Position Pos
+	X        Expr
+	Cases    []*Case
+}
+
+// Case is a "- case List" line nested beneath a switch, or a "- default" line if List is nil.
+// Body holds the nodes nested beneath it, which are rendered if X equals one of the values of
+// List, or if no case matches for the default.
+type Case struct {
+	Position Pos
+	List     []Expr
+	Body     []Node
+}
+
+// Assign is a "- Name := X" line, which declares Name in the enclosing block, or a "- Name = X"
+// line, which assigns to a Name declared before, if Define is false. The bodies of ranges, elses,
+// cases and mixins are blocks; the nodes nested within tags are part of the block of the tag.
+type Assign struct {
+	Position Pos
+	Name     string
+	X        Expr
+	Define   bool
+}
+
+// Extends is a "- extends \"Name\"" line, which renders the template inside the layout Name.
+// It only appears at the top level of a file.
+type Extends struct {
+	Position Pos
+	Name     string
+}
+
+// Block is a "- block Name" line. In a layout, Body is the content rendered unless a template
+// that extends the layout gives its own; in a template that extends a layout, Body is that
+// content.
+type Block struct {
+	Position Pos
+	Name     string
+	Body     []Node
+}
+
+// Yield is a "= yield" line, where a layout renders the content of the template that extends
+// it that is not part of a block.
+type Yield struct {
+	Position Pos
+}
+
+// ContentFor is a "- content_for \"Name\"" line. Body holds the nodes nested beneath it, which
+// are rendered into the slot Name instead of in place.
+type ContentFor struct {
+	Position Pos
+	Name     string
+	Body     []Node
+}
+
+// Content is a "= content \"Name\"" line, which writes what was rendered into the slot Name.
+type Content struct {
+	Position Pos
+	Name     string
+}
+
+// Def is a "- def Name(Params)" line that defines a mixin at the top level of a file. Body holds
+// the nodes nested beneath it.
+type Def struct {
+	Position Pos
+	Name     string
+	Params   []string
+	Body     []Node
+}
+
+// Call is a "+Name(Args)" line that renders the mixin Name with its parameters set to Args.
+// Body holds the nodes nested beneath it, which the mixin renders where it says "= yield".
+type Call struct {
+	Position Pos
+	Name     string
+	Args     []Expr
+	Body     []Node
+}
+
+// Render is a "= render \"Name\", local: X" line, which renders the template Name with the
+// given locals as its scope, or a "= render_each \"Name\", Collection" line, which renders it
+// once for every element of Collection.
+type Render struct {
+	Position   Pos
+	Name       string
+	Collection Expr
+	Locals     []*Local
+}
+
+// Local is a variable passed to a partial.
+type Local struct {
+	Name string
+	X    Expr
+}
+
+func (self *File) Pos() Pos       { return Pos{1, 1} }
+func (self *Doctype) Pos() Pos    { return self.Position }
+func (self *Tag) Pos() Pos        { return self.Position }
+func (self *Text) Pos() Pos       { return self.Position }
+func (self *Script) Pos() Pos     { return self.Position }
+func (self *Range) Pos() Pos      { return self.Position }
+func (self *Else) Pos() Pos       { return self.Position }
+func (self *Break) Pos() Pos      { return self.Position }
+func (self *Continue) Pos() Pos   { return self.Position }
+func (self *Switch) Pos() Pos     { return self.Position }
+func (self *Case) Pos() Pos       { return self.Position }
+func (self *Assign) Pos() Pos     { return self.Position }
+func (self *Extends) Pos() Pos    { return self.Position }
+func (self *Block) Pos() Pos      { return self.Position }
+func (self *Yield) Pos() Pos      { return self.Position }
+func (self *ContentFor) Pos() Pos { return self.Position }
+func (self *Content) Pos() Pos    { return self.Position }
+func (self *Def) Pos() Pos        { return self.Position }
+func (self *Call) Pos() Pos       { return self.Position }
+func (self *Render) Pos() Pos     { return self.Position }
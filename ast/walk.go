@@ -0,0 +1,77 @@
+package ast
+
+import "fmt"
+
+// A Visitor's Visit method is invoked for each node encountered by Walk. If the result visitor w
+// is not nil, Walk visits each of the children of node with the visitor w, followed by a call of
+// w.Visit(nil).
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the tree in depth-first order. It starts by calling v.Visit(node); node must not
+// be nil. The inline content of a tag is visited before its children.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+
+	switch n := node.(type) {
+	case *File:
+		walkList(v, n.Nodes)
+	case *Tag:
+		if n.Inline != nil {
+			Walk(v, n.Inline)
+		}
+		walkList(v, n.Children)
+	case *Range:
+		walkList(v, n.Body)
+		if n.Else != nil {
+			Walk(v, n.Else)
+		}
+	case *Else:
+		walkList(v, n.Body)
+	case *Switch:
+		for _, c := range n.Cases {
+			Walk(v, c)
+		}
+	case *Case:
+		walkList(v, n.Body)
+	case *Block:
+		walkList(v, n.Body)
+	case *ContentFor:
+		walkList(v, n.Body)
+	case *Def:
+		walkList(v, n.Body)
+	case *Call:
+		walkList(v, n.Body)
+	case *Doctype, *Text, *Script, *Assign, *Break, *Continue, *Extends, *Yield, *Content, *Render:
+		// nothing to do
+	default:
+		panic(fmt.Sprintf("ast.Walk: unexpected node type %T", n))
+	}
+
+	v.Visit(nil)
+}
+
+func walkList(v Visitor, list []Node) {
+	for _, n := range list {
+		Walk(v, n)
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the tree in depth-first order. It starts by calling f(node); node must not be
+// nil. If f returns true, Inspect invokes f recursively for each of the children of node,
+// followed by a call of f(nil).
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
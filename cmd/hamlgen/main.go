@@ -0,0 +1,120 @@
+// Command hamlgen compiles a HAML template into a Go function that renders it without
+// reflection. It is meant to be run by go generate from the directory of the package that
+// declares the type of the data the template renders:
+//
+//	//go:generate hamlgen -type Page page.haml
+//
+// writes page_haml.go, which declares
+//
+//	func RenderPage(w io.Writer, data *Page) error
+//
+// The flags are:
+//
+//	-type T       the type of the data, declared in the package in the current directory
+//	-func name    the name of the generated function; RenderT by default
+//	-o file       the file to write; the template's name with "_haml.go" by default
+//	-indent s     the indentation of the generated markup; a tab by default
+//	-autoclose    whether empty tags are closed with " />"; true by default
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/build"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/realistschuckle/gohaml"
+)
+
+var (
+	typeName  = flag.String("type", "", "the type of the data, declared in the package in the current directory")
+	funcName  = flag.String("func", "", "the name of the generated function; RenderT by default")
+	output    = flag.String("o", "", "the file to write; the template's name with \"_haml.go\" by default")
+	indent    = flag.String("indent", "\t", "the indentation of the generated markup")
+	autoclose = flag.Bool("autoclose", true, "whether empty tags are closed with \" />\"")
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: hamlgen -type T [flags] template.haml\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if *typeName == "" || flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if err := generate(flag.Arg(0)); err != nil {
+		fmt.Fprintf(os.Stderr, "hamlgen: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func generate(template string) (err error) {
+	if *funcName == "" {
+		*funcName = "Render" + *typeName
+	}
+	if *output == "" {
+		*output = strings.TrimSuffix(template, filepath.Ext(template)) + "_haml.go"
+	}
+
+	var src []byte
+	if src, err = ioutil.ReadFile(template); err != nil {
+		return
+	}
+	var engine *gohaml.Engine
+	if engine, err = gohaml.NewEngineAll(string(src), 0); err != nil {
+		return fmt.Errorf("%s: %s", template, err)
+	}
+	engine.Indentation = *indent
+	engine.Autoclose = *autoclose
+
+	var pkg *types.Package
+	if pkg, err = loadPackage("."); err != nil {
+		return
+	}
+	obj, ok := pkg.Scope().Lookup(*typeName).(*types.TypeName)
+	if !ok {
+		return fmt.Errorf("type %s is not declared in package %s", *typeName, pkg.Name())
+	}
+
+	var buf bytes.Buffer
+	if err = engine.GenerateGo(&buf, pkg, *funcName, obj.Type()); err != nil {
+		return
+	}
+	return ioutil.WriteFile(*output, buf.Bytes(), 0644)
+}
+
+// loadPackage type-checks the package in dir, leaving out the file that is about to be
+// generated. Type errors are ignored since the package may not compile before the generated
+// function exists.
+func loadPackage(dir string) (pkg *types.Package, err error) {
+	var bp *build.Package
+	if bp, err = build.ImportDir(dir, 0); err != nil {
+		return
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range bp.GoFiles {
+		if filepath.Join(dir, name) == filepath.Join(dir, *output) {
+			continue
+		}
+		var f *ast.File
+		if f, err = parser.ParseFile(fset, filepath.Join(dir, name), nil, 0); err != nil {
+			return
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: importer.ForCompiler(fset, "source", nil), Error: func(error) {}}
+	pkg, _ = conf.Check(bp.ImportPath, fset, files, nil)
+	return
+}
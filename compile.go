@@ -0,0 +1,427 @@
+package gohaml
+
+import (
+	"bytes"
+	"path"
+	"strings"
+)
+
+// A program is the flattened form of a tree for a given indentation and autoclose setting.
+// Everything that does not depend on the scope is rendered ahead of time into chunks; the other
+// instructions evaluate the parts of the template that do.
+type program []instr
+
+type instr interface {
+	exec(st *state)
+}
+
+// chunk is markup that is written as is.
+type chunk string
+
+// hole writes a value looked up in the scope.
+type hole struct {
+	value res
+}
+
+// attrs writes attributes of which at least one key or value is looked up in the scope.
+type attrs struct {
+	pairs []*resPair
+}
+
+// inline writes the content of a tag whose content is looked up in the scope. A non-empty value
+// is written between '>' and close; an empty one leaves the tag empty, which writes empty.
+type inline struct {
+	value res
+	close string
+	empty string
+}
+
+// loop renders each of its children once for every element of x, separating the children by sep
+// unless they were rendered for the last element or do not end in a newline. last is the index of
+// the last child that is not silent, after which no separator is written but between elements. A blank key or
+// value is not bound; a loop over a channel with a single variable binds the elements to key.
+// If there is nothing to range over, it runs otherwise instead.
+type loop struct {
+	key, value string
+	x          res
+	children   []loopChild
+	sep        string
+	last       int
+	otherwise  program
+}
+
+type loopChild struct {
+	body program
+	sep  bool
+}
+
+// choice runs the body of the first case that has a value equal to x, or otherwise if there is
+// none.
+type choice struct {
+	x         res
+	cases     []choiceCase
+	otherwise program
+}
+
+type choiceCase struct {
+	values []res
+	body   program
+}
+
+// optional runs a loop or a switch, which may write nothing. When it does, it removes the
+// separator written before it, or if there is none, has the separator after it left out, so
+// that no empty line is left where it stands. after tells whether a separator follows it.
+type optional struct {
+	body  program
+	sep   string
+	after bool
+}
+
+// separator is written after an optional instruction unless that wrote nothing.
+type separator string
+
+// flow tells a loop how to go on after a child of its body ran.
+type flow int
+
+const (
+	flowNext flow = iota
+	flowBreak
+	flowContinue
+)
+
+// branch is a break or a continue, which stops the program that holds it and tells the loop it
+// is nested in how to go on.
+type branch flow
+
+// slot writes the content given for it by a template that extends the layout, or its body if
+// there is none. Lines of the content after the first are indented by indent.
+type slot struct {
+	name   string
+	body   program
+	indent string
+}
+
+// define renders its body as the content of the layout's block with the given name, unless a
+// template that extends this one gave content for it already.
+type define struct {
+	name string
+	body program
+}
+
+// yield writes the content of the template that extends the layout that is not part of a block,
+// or the block that a mixin is called with. Where nothing yields, like in a template that is not
+// used as a layout, it writes the value of the variable yield instead, as scripts used to.
+type yield struct {
+	indent string
+	value  res
+}
+
+// capture appends what its body renders to the slot with the given name, separated by a newline
+// from what was there already.
+type capture struct {
+	name string
+	body program
+}
+
+// content writes what was captured for the slot with the given name. Lines after the first are
+// indented by indent.
+type content struct {
+	name   string
+	indent string
+}
+
+// mixin is the compiled body of a "- def".
+type mixin struct {
+	name   string
+	params []string
+	body   program
+}
+
+// call renders a mixin in a scope holding only its parameters, with block as the content the
+// mixin yields. Lines after the first are indented by indent.
+type call struct {
+	mixin  *mixin
+	args   []res
+	block  program
+	indent string
+}
+
+// partial renders the template name, loaded with the Loader, in a scope holding only its locals.
+// If each is set, it renders it once for every element of collection, which it stores in the
+// scope as as, along with its index as as + "_index" and a Loop as loop. Lines after the first are indented by
+// indent.
+type partial struct {
+	name       string
+	locals     []string
+	values     []res
+	each       bool
+	collection res
+	as         string
+	indent     string
+}
+
+// assign stores a literal value in the scope, declaring name if define is set.
+type assign struct {
+	name   string
+	value  interface{}
+	define bool
+}
+
+// vassign stores a value looked up in the scope in the scope, declaring name if define is set.
+type vassign struct {
+	name   string
+	value  res
+	define bool
+}
+
+// size returns the length of the markup in the chunks of the program, which is the least that it
+// writes.
+func (self program) size() (n int) {
+	for _, i := range self {
+		if c, ok := i.(chunk); ok {
+			n += len(c)
+		}
+	}
+	return
+}
+
+// emitter collects instructions, merging consecutive chunks.
+type emitter struct {
+	instrs program
+}
+
+func (self *emitter) text(s string) {
+	if len(s) == 0 {
+		return
+	}
+	if n := len(self.instrs); n > 0 {
+		if c, ok := self.instrs[n-1].(chunk); ok {
+			self.instrs[n-1] = c + chunk(s)
+			return
+		}
+	}
+	self.instrs = append(self.instrs, chunk(s))
+}
+
+func (self *emitter) add(i instr) {
+	self.instrs = append(self.instrs, i)
+}
+
+// separate writes s between two nodes. If the node before it is optional, s is left out when
+// that node writes nothing.
+func (self *emitter) separate(s string) {
+	if o := lastOptional(self.instrs); o != nil {
+		o.after = true
+		self.add(separator(s))
+		return
+	}
+	self.text(s)
+}
+
+// lastOptional returns the last instruction of p if it is optional, or nil.
+func lastOptional(p program) *optional {
+	if n := len(p); n > 0 {
+		if o, ok := p[n-1].(*optional); ok {
+			return o
+		}
+	}
+	return nil
+}
+
+type compiler struct {
+	indent    string
+	autoclose bool
+	mixins    map[string]*mixin
+}
+
+// compile flattens the tree into a program that renders the same markup as the tree would with
+// the given indentation and autoclose setting.
+func compile(t *tree, indent string, autoclose bool) program {
+	c := &compiler{indent, autoclose, make(map[string]*mixin)}
+	// Mixins are entered before their bodies are compiled so that they can call each other.
+	for name, d := range t.mixins {
+		c.mixins[name] = &mixin{name: name, params: d._params}
+	}
+	for name, d := range t.mixins {
+		c.mixins[name].body = c.list(d._children, "")
+	}
+	e := new(emitter)
+	last := len(t.nodes) - 1
+	for last >= 0 && isSilent(t.nodes[last]) {
+		last--
+	}
+	for i, n := range t.nodes {
+		c.node(n, e, "")
+		if i < last && !n.noNewline() {
+			e.separate("\n")
+		}
+	}
+	return e.instrs
+}
+
+// isSilent reports whether n renders nothing where it stands, so that no newline is written
+// before or after it.
+func isSilent(n inode) bool {
+	switch n.(type) {
+	case *definenode, *contentfornode, *branchnode, *declassnode, *vdeclassnode:
+		return true
+	}
+	return false
+}
+
+// list compiles nodes that follow one another at the same indentation, like the body of a loop
+// or a block.
+func (self *compiler) list(nodes []inode, curIndent string) program {
+	e := new(emitter)
+	last := len(nodes) - 1
+	for last >= 0 && isSilent(nodes[last]) {
+		last--
+	}
+	for i, n := range nodes {
+		self.node(n, e, curIndent)
+		if i < last && !n.noNewline() {
+			e.separate("\n" + curIndent)
+		}
+	}
+	return e.instrs
+}
+
+func (self *compiler) node(n inode, e *emitter, curIndent string) {
+	switch n := n.(type) {
+	case *node:
+		self.tag(n, e, curIndent)
+	case *rangenode:
+		l := &loop{key: n._lhs1, value: n._lhs2, x: n._rhs, sep: "\n" + curIndent, last: len(n._children) - 1}
+		for l.last >= 0 && isSilent(n._children[l.last]) {
+			l.last--
+		}
+		for _, child := range n._children {
+			body := new(emitter)
+			self.node(child, body, curIndent)
+			sep := !child.noNewline()
+			if o := lastOptional(body.instrs); o != nil && sep {
+				o.after = true
+			}
+			l.children = append(l.children, loopChild{body.instrs, sep})
+		}
+		if n._else != nil {
+			l.otherwise = self.list(n._else, curIndent)
+		}
+		e.add(&optional{body: program{l}, sep: "\n" + curIndent})
+	case *declassnode:
+		e.add(&assign{n._lhs, n._rhs, n._define})
+	case *vdeclassnode:
+		e.add(&vassign{n._lhs, n._rhs, n._define})
+	case *switchnode:
+		c := &choice{x: n._x}
+		for _, cn := range n._cases {
+			body := self.list(cn._children, curIndent)
+			if cn._default {
+				c.otherwise = body
+			} else {
+				c.cases = append(c.cases, choiceCase{cn._values, body})
+			}
+		}
+		e.add(&optional{body: program{c}, sep: "\n" + curIndent})
+	case *branchnode:
+		if n._continue {
+			e.add(branch(flowContinue))
+		} else {
+			e.add(branch(flowBreak))
+		}
+	case *blocknode:
+		e.add(&slot{n._name, self.list(n._children, curIndent), curIndent})
+	case *definenode:
+		e.add(&define{n._name, self.list(n._children, curIndent)})
+	case *yieldnode:
+		e.add(&yield{curIndent, n._value})
+	case *contentfornode:
+		e.add(&capture{n._name, self.list(n._children, curIndent)})
+	case *contentnode:
+		e.add(&content{n._name, curIndent})
+	case *rendernode:
+		p := &partial{name: n._name, locals: n._locals, values: n._values, indent: curIndent}
+		if n._collection != nil {
+			p.each, p.collection = true, *n._collection
+			p.as = strings.TrimSuffix(path.Base(n._name), path.Ext(n._name))
+		}
+		e.add(p)
+	case *callnode:
+		e.add(&call{self.mixins[n._name], n._args, self.list(n._children, ""), curIndent})
+	}
+}
+
+func (self *compiler) tag(n *node, e *emitter, curIndent string) {
+	if n._name == "doctype" {
+		e.text(doctype(n._remainder.value))
+		return
+	}
+	name := n._name
+	if len(n._attrs) > 0 && len(name) == 0 {
+		name = "div"
+	}
+	if len(name) == 0 {
+		if n._remainder.needsResolution {
+			e.add(&hole{n._remainder})
+		} else {
+			e.text(n._remainder.value)
+		}
+		return
+	}
+	e.text("<" + name)
+	self.attrs(n._attrs, e)
+	if n._remainder.needsResolution {
+		e.add(&inline{n._remainder, "</" + name + ">", self.emptyClose(n)})
+	} else if len(n._remainder.value) > 0 {
+		e.text(">" + n._remainder.value + "</" + name + ">")
+	} else {
+		self.children(n, name, e, curIndent)
+	}
+}
+
+func (self *compiler) attrs(pairs []*resPair, e *emitter) {
+	static := make([]string, 0, 2*len(pairs))
+	for _, pair := range pairs {
+		if pair.key.needsResolution || pair.value.needsResolution {
+			e.add(&attrs{pairs})
+			return
+		}
+		static = append(static, pair.key.value, pair.value.value)
+	}
+	var buf bytes.Buffer
+	WriteAttrs(&buf, static...)
+	e.text(buf.String())
+}
+
+func (self *compiler) children(n *node, name string, e *emitter, curIndent string) {
+	if len(n._children) == 0 {
+		e.text(self.emptyClose(n))
+		return
+	}
+	ind := curIndent + self.indent
+	if n._noNewline {
+		ind = curIndent
+	}
+	e.text(">")
+	written := 0
+	for _, child := range n._children {
+		if !isSilent(child) {
+			if written != 0 || !n._noNewline {
+				e.text("\n" + ind)
+			}
+			written++
+		}
+		self.node(child, e, ind)
+	}
+	if !n._noNewline {
+		e.text("\n" + curIndent)
+	}
+	e.text("</" + name + ">")
+}
+
+func (self *compiler) emptyClose(n *node) string {
+	if self.autoclose || n._autoclose {
+		return " />"
+	}
+	return ">"
+}
@@ -0,0 +1,69 @@
+package gohaml
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Error describes a single syntax error found while parsing a template. Line and Column are
+// 1-based; Column points at the first non-whitespace character of the offending line. File names
+// the template the error was found in when it was parsed as part of a Set, and is empty otherwise.
+type Error struct {
+	Line   int
+	Column int
+	Msg    string
+	File   string
+}
+
+func (self *Error) Error() string {
+	if self.File != "" {
+		return fmt.Sprintf("Syntax error in %s on line %d: %s\n", self.File, self.Line, self.Msg)
+	}
+	return fmt.Sprintf("Syntax error on line %d: %s\n", self.Line, self.Msg)
+}
+
+// ErrorList is a list of syntax errors. NewEngineAll returns one, sorted by position, when a
+// template contains more than a single mistake, and NewSet one sorted by file, then by position.
+type ErrorList []*Error
+
+// Add appends an Error with the given position and message to the list.
+func (self *ErrorList) Add(line int, column int, msg string) {
+	*self = append(*self, &Error{Line: line, Column: column, Msg: msg})
+}
+
+func (self ErrorList) Len() int      { return len(self) }
+func (self ErrorList) Swap(i, j int) { self[i], self[j] = self[j], self[i] }
+
+func (self ErrorList) Less(i, j int) bool {
+	if self[i].File != self[j].File {
+		return self[i].File < self[j].File
+	}
+	if self[i].Line != self[j].Line {
+		return self[i].Line < self[j].Line
+	}
+	return self[i].Column < self[j].Column
+}
+
+// Sort sorts the list by file, then by line, then by column.
+func (self ErrorList) Sort() {
+	sort.Stable(self)
+}
+
+// Error reports the first error and the number of errors that follow it.
+func (self ErrorList) Error() string {
+	switch len(self) {
+	case 0:
+		return "no errors"
+	case 1:
+		return self[0].Error()
+	}
+	return fmt.Sprintf("%s(and %d more errors)\n", self[0], len(self)-1)
+}
+
+// Err returns the list as an error, or nil if it is empty.
+func (self ErrorList) Err() error {
+	if len(self) == 0 {
+		return nil
+	}
+	return self
+}
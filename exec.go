@@ -0,0 +1,687 @@
+package gohaml
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// state is what a program needs while it renders: the scope and the value the template was
+// rendered with, the globals beneath them, the buffer it writes to, the
+// content that the templates extending the one being rendered gave, the content captured into
+// slots so far, the settings and Loader of the render, its context, its limits and how much of
+// them it used, and the first error that occurred.
+type state struct {
+	scope      map[string]interface{}
+	root       reflect.Value
+	globals    map[string]interface{}
+	buf        *bytes.Buffer
+	blocks     map[string]string
+	yield      *string
+	slots      map[string]string
+	indent     string
+	autoclose  bool
+	keyLess    func(a, b interface{}) bool
+	formatters map[reflect.Type]func(interface{}) string
+	helpers    map[string]interface{}
+	loader     Loader
+	flow       flow
+	skip       bool
+	shadowed   []binding
+	err        error
+
+	ctx           context.Context
+	maxIterations int
+	maxDepth      int
+	maxOutput     int
+	iterations    int
+	depth         int
+	// base is the length of the buffers that the one being written to is captured for.
+	base int
+}
+
+// binding is the value that a name had in the scope before it was declared in a block, if it had
+// one.
+type binding struct {
+	name  string
+	value interface{}
+	ok    bool
+}
+
+// lookup returns the value of the variable name: the value of name in the scope, or else the
+// field, the result of the method without parameters or the map entry of the root value named
+// name, or else the global of that name.
+func (self *state) lookup(name string) reflect.Value {
+	if v, ok := self.scope[name]; ok {
+		return reflect.ValueOf(v)
+	}
+	if self.root.IsValid() {
+		if m := self.root.MethodByName(name); m.IsValid() && m.Type().NumIn() == 0 && m.Type().NumOut() == 1 {
+			return m.Call(nil)[0]
+		}
+		if root := reflect.Indirect(self.root); root.Kind() == reflect.Struct || root.Kind() == reflect.Map {
+			if v := member(root, name); v.IsValid() {
+				return v
+			}
+		}
+	}
+	return reflect.ValueOf(self.globals[name])
+}
+
+// declare sets name to v in the scope, keeping the value that it had for unwind to put back when
+// the block that declares it ends.
+func (self *state) declare(name string, v interface{}) {
+	old, ok := self.scope[name]
+	self.shadowed = append(self.shadowed, binding{name, old, ok})
+	self.scope[name] = v
+}
+
+// bind declares name like declare does unless name is blank.
+func (self *state) bind(name string, v interface{}) {
+	if name != "" && name != "_" {
+		self.declare(name, v)
+	}
+}
+
+// set declares name with the value v, or if define is false, assigns v to it, which fails unless
+// name is declared.
+func (self *state) set(name string, v interface{}, define bool) {
+	if define {
+		self.declare(name, v)
+	} else if _, ok := self.scope[name]; !ok {
+		self.fail(fmt.Errorf("gohaml: cannot assign to %s, which is not declared", name))
+	} else {
+		self.scope[name] = v
+	}
+}
+
+// unwind ends the blocks entered since len(self.shadowed) was mark, putting back the values that
+// the names declared in them had before.
+func (self *state) unwind(mark int) {
+	for i := len(self.shadowed) - 1; i >= mark; i-- {
+		if b := self.shadowed[i]; b.ok {
+			self.scope[b.name] = b.value
+		} else {
+			delete(self.scope, b.name)
+		}
+	}
+	self.shadowed = self.shadowed[:mark]
+}
+
+// fail records err unless an error occurred before.
+func (self *state) fail(err error) {
+	if self.err == nil {
+		self.err = err
+	}
+}
+
+// check fails the render if it is canceled, ran out of time or wrote more than its limit allows,
+// and reports whether it goes on, which it does not after an error.
+func (self *state) check() bool {
+	if self.err != nil {
+		return false
+	}
+	if err := self.ctx.Err(); err != nil {
+		self.fail(fmt.Errorf("gohaml: render stopped: %w", err))
+		return false
+	}
+	return self.fits(0)
+}
+
+// fits reports whether n more bytes of output stay within the limit, failing the render if not.
+func (self *state) fits(n int) bool {
+	if self.maxOutput > 0 && self.base+self.buf.Len()+n > self.maxOutput {
+		self.fail(fmt.Errorf("gohaml: render exceeds the maximum output of %d bytes", self.maxOutput))
+		return false
+	}
+	return true
+}
+
+// write appends s to the output unless that takes it beyond the limit, so that a single large
+// value cannot grow the buffer past it.
+func (self *state) write(s string) {
+	if self.fits(len(s)) {
+		self.buf.WriteString(s)
+	}
+}
+
+// iterate counts an iteration of a loop and reports whether the render goes on.
+func (self *state) iterate() bool {
+	if self.iterations++; self.maxIterations > 0 && self.iterations > self.maxIterations {
+		self.fail(fmt.Errorf("gohaml: render exceeds the maximum of %d loop iterations", self.maxIterations))
+	}
+	return self.check()
+}
+
+// defaultMaxDepth is how deeply mixins and partials may be nested when MaxDepth is zero, so that
+// a mixin or a partial that renders itself fails the render instead of overflowing the stack.
+const defaultMaxDepth = 1000
+
+// enter counts a mixin or a partial, as kind says, that starts rendering, failing the render if
+// that nests them deeper than its limit allows. It reports whether the render goes on; if it
+// does, leave must be called when the mixin or partial is done.
+func (self *state) enter(kind string, name string) bool {
+	max := self.maxDepth
+	if max <= 0 {
+		max = defaultMaxDepth
+	}
+	if self.depth >= max {
+		self.fail(fmt.Errorf("gohaml: %s %s is nested more than %d deep", kind, name, max))
+		return false
+	}
+	self.depth++
+	return true
+}
+
+func (self *state) leave() {
+	self.depth--
+}
+
+// recv receives from the channel t like t.Recv does, unless the render is canceled first.
+func (self *state) recv(t reflect.Value) (v reflect.Value, ok bool) {
+	if self.ctx.Done() == nil {
+		return t.Recv()
+	}
+	cases := []reflect.SelectCase{
+		{Dir: reflect.SelectRecv, Chan: t},
+		{Dir: reflect.SelectRecv, Chan: reflect.ValueOf(self.ctx.Done())},
+	}
+	if i, x, received := reflect.Select(cases); i == 0 {
+		return x, received
+	}
+	self.check()
+	return
+}
+
+// load loads the template with the given name, which is a layout or a partial as kind says, with
+// the Loader of the template being rendered. It returns nil if that fails.
+func (self *state) load(kind string, name string) (engine *Engine) {
+	if self.loader == nil {
+		self.fail(fmt.Errorf("gohaml: cannot load %s %s without a Loader", kind, name))
+		return
+	}
+	var err error
+	if engine, err = self.loader.Load(name); err != nil {
+		self.fail(fmt.Errorf("gohaml: %s %s: %s", kind, name, err))
+		engine = nil
+	}
+	return
+}
+
+// exec runs the instructions of the program until a break or a continue is executed, or the
+// render stops.
+func (self program) exec(st *state) {
+	for _, i := range self {
+		if !st.check() {
+			return
+		}
+		if i.exec(st); st.flow != flowNext {
+			return
+		}
+	}
+}
+
+// capture renders the program into a buffer of its own and returns what it wrote.
+func (self program) capture(st *state) string {
+	var buf bytes.Buffer
+	outer := st.buf
+	st.buf, st.base = &buf, st.base+outer.Len()
+	self.exec(st)
+	st.buf, st.base = outer, st.base-outer.Len()
+	return buf.String()
+}
+
+func (self chunk) exec(st *state) {
+	st.write(string(self))
+}
+
+func (self *hole) exec(st *state) {
+	st.write(self.value.resolve(st))
+}
+
+func (self *attrs) exec(st *state) {
+	pairs := make([]string, 0, 2*len(self.pairs))
+	for _, pair := range self.pairs {
+		pairs = append(pairs, pair.key.resolve(st), pair.value.resolve(st))
+	}
+	var buf bytes.Buffer
+	WriteAttrs(&buf, pairs...)
+	st.write(buf.String())
+}
+
+func (self *inline) exec(st *state) {
+	if value := self.value.resolve(st); len(value) > 0 {
+		st.write(">" + value + self.close)
+	} else {
+		st.write(self.empty)
+	}
+}
+
+// Loop describes the iteration of a range that is under way. The body of a range sees it as
+// loop, so that it can tell "loop.Last" or "loop.Index1".
+type Loop struct {
+	Index  int   // the number of the iteration, counting from 0
+	Index1 int   // the number of the iteration, counting from 1
+	First  bool  // whether this is the first iteration
+	Last   bool  // whether this is the last iteration
+	Length int   // the number of iterations
+	Odd    bool  // whether Index1 is odd
+	Even   bool  // whether Index1 is even
+	Parent *Loop // the loop of the range this one is nested in, or nil
+}
+
+func newLoop(index int, length int, last bool, parent *Loop) *Loop {
+	return &Loop{index, index + 1, index == 0, last, length, index%2 == 0, index%2 == 1, parent}
+}
+
+// exec binds the elements of x like the range clause of a for statement does: integers are
+// ranged over from zero, strings by rune, with each rune as a string of its own, maps in the
+// order of their keys and channels until they are closed. For a channel, loop.Length is -1; since
+// the loop receives the next element before it renders the body for the current one, a break
+// leaves one element fewer in the channel than it would in Go.
+func (self *loop) exec(st *state) {
+	scope := st.scope
+	parent, _ := scope["loop"].(*Loop)
+	key, value := self.key, self.value
+	empty, start := true, st.buf.Len()
+
+	// each renders the body for one element and reports whether the loop goes on. The iteration
+	// variables and the names declared in the body are gone after each element.
+	each := func(i, length int, k, v interface{}, more bool) bool {
+		empty = false
+		if !st.iterate() {
+			return false
+		}
+		mark := len(st.shadowed)
+		st.bind(key, k)
+		st.bind(value, v)
+		st.declare("loop", newLoop(i, length, !more, parent))
+		self.body(st, more)
+		st.unwind(mark)
+		f := st.flow
+		st.flow = flowNext
+		if f == flowBreak || f == flowContinue && !more {
+			self.trim(st, start)
+		}
+		return f != flowBreak
+	}
+	switch t := self.x.evaluate(st); t.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < t.Len(); i++ {
+			if !each(i, t.Len(), i, elem(t.Index(i)), i != t.Len()-1) {
+				break
+			}
+		}
+	case reflect.Map:
+		keys := t.MapKeys()
+		st.sortKeys(keys)
+		for i, k := range keys {
+			if !each(i, len(keys), elem(k), elem(t.MapIndex(k)), i != len(keys)-1) {
+				break
+			}
+		}
+	case reflect.String:
+		s := t.String()
+		length, i := utf8.RuneCountInString(s), 0
+		for offset, r := range s {
+			if !each(i, length, offset, string(r), i != length-1) {
+				break
+			}
+			i++
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if value != "" {
+			st.fail(fmt.Errorf("gohaml: range over %s permits only one iteration variable", self.x.value))
+			break
+		}
+		var n int
+		if t.Kind() >= reflect.Uint {
+			n = int(t.Uint())
+		} else {
+			n = int(t.Int())
+		}
+		for i := 0; i < n; i++ {
+			if !each(i, n, i, nil, i != n-1) {
+				break
+			}
+		}
+	case reflect.Chan:
+		if value != "" {
+			st.fail(fmt.Errorf("gohaml: range over %s permits only one iteration variable", self.x.value))
+			break
+		}
+		if t.IsNil() || t.Type().ChanDir()&reflect.RecvDir == 0 {
+			break
+		}
+		key, value = "", key
+		v, ok := st.recv(t)
+		for i := 0; ok; i++ {
+			next, more := st.recv(t)
+			if !each(i, -1, nil, elem(v), more) {
+				break
+			}
+			v, ok = next, more
+		}
+	}
+
+	if empty && st.err == nil {
+		mark := len(st.shadowed)
+		self.otherwise.exec(st)
+		st.unwind(mark)
+	}
+}
+
+// body renders the children for one element. Only the separator after the last child of the
+// last element is left out, since the children of the last element are followed by the children
+// of the loop's parent.
+func (self *loop) body(st *state, more bool) {
+	for i, child := range self.children {
+		if child.body.exec(st); st.flow != flowNext {
+			return
+		}
+		skip := st.skip
+		st.skip = false
+		if child.sep && !skip && (more || i < self.last) {
+			st.write(self.sep)
+		}
+	}
+}
+
+// trim removes the separator that the loop wrote last if nothing follows it, since a break, or a
+// continue for the last element, leaves the children of the loop's parent to follow it.
+func (self *loop) trim(st *state, start int) {
+	if n := st.buf.Len() - len(self.sep); n >= start && bytes.HasSuffix(st.buf.Bytes(), []byte(self.sep)) {
+		st.buf.Truncate(n)
+	}
+}
+
+func (self *optional) exec(st *state) {
+	start := st.buf.Len()
+	if self.body.exec(st); st.flow != flowNext || st.buf.Len() != start {
+		return
+	}
+	if n := start - len(self.sep); n >= 0 && bytes.HasSuffix(st.buf.Bytes(), []byte(self.sep)) {
+		st.buf.Truncate(n)
+	} else if self.after {
+		st.skip = true
+	}
+}
+
+func (self separator) exec(st *state) {
+	if st.skip {
+		st.skip = false
+		return
+	}
+	st.write(string(self))
+}
+
+func (self *choice) exec(st *state) {
+	mark := len(st.shadowed)
+	self.body(st, self.x.evaluate(st)).exec(st)
+	st.unwind(mark)
+}
+
+// body returns the body of the first case that has a value equal to x, or otherwise.
+func (self *choice) body(st *state, x reflect.Value) program {
+	for _, c := range self.cases {
+		for _, v := range c.values {
+			if equal(x, v.resolveValue(st)) {
+				return c.body
+			}
+		}
+	}
+	return self.otherwise
+}
+
+// Equal reports whether a switch of a template that is given a takes a case with the value b. It
+// is used by the code that GenerateGo writes.
+func Equal(a interface{}, b interface{}) bool {
+	return equal(reflect.ValueOf(a), reflect.ValueOf(b))
+}
+
+// equal compares a and b the way Go compares a value with a constant: numbers by value whatever
+// their types, strings and bools by value if their kinds match, so that values of named types
+// equal the literals of their underlying types, and other values only if they are of the same
+// comparable type.
+func equal(a reflect.Value, b reflect.Value) bool {
+	for a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	if !a.IsValid() || !b.IsValid() {
+		return a.IsValid() == b.IsValid()
+	}
+	ka, kb := numberKind(a.Kind()), numberKind(b.Kind())
+	switch {
+	case ka == reflect.Int && kb == reflect.Int:
+		return a.Int() == b.Int()
+	case ka == reflect.Uint && kb == reflect.Uint:
+		return a.Uint() == b.Uint()
+	case ka == reflect.Int && kb == reflect.Uint:
+		return a.Int() >= 0 && uint64(a.Int()) == b.Uint()
+	case ka == reflect.Uint && kb == reflect.Int:
+		return b.Int() >= 0 && uint64(b.Int()) == a.Uint()
+	case ka != reflect.Invalid && kb != reflect.Invalid:
+		return toFloat(a) == toFloat(b)
+	case a.Kind() == reflect.String && b.Kind() == reflect.String:
+		return a.String() == b.String()
+	case a.Kind() == reflect.Bool && b.Kind() == reflect.Bool:
+		return a.Bool() == b.Bool()
+	case a.Type() == b.Type() && a.Type().Comparable() && a.CanInterface() && b.CanInterface():
+		return a.Interface() == b.Interface()
+	}
+	return false
+}
+
+// numberKind returns reflect.Int, reflect.Uint or reflect.Float64 for the kinds of integers,
+// unsigned integers and floats, and reflect.Invalid for the others.
+func numberKind(k reflect.Kind) reflect.Kind {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return reflect.Int
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return reflect.Uint
+	case reflect.Float32, reflect.Float64:
+		return reflect.Float64
+	}
+	return reflect.Invalid
+}
+
+func toFloat(v reflect.Value) float64 {
+	switch numberKind(v.Kind()) {
+	case reflect.Int:
+		return float64(v.Int())
+	case reflect.Uint:
+		return float64(v.Uint())
+	}
+	return v.Float()
+}
+
+func (self branch) exec(st *state) {
+	st.flow = flow(self)
+}
+
+// sortKeys sorts the keys of a map with the KeyLess function of the Engine, or else like
+// text/template does: numbers numerically, strings lexically and false before true.
+func (self *state) sortKeys(keys []reflect.Value) {
+	if self.keyLess != nil {
+		sort.SliceStable(keys, func(i, j int) bool {
+			return self.keyLess(elem(keys[i]), elem(keys[j]))
+		})
+		return
+	}
+	sort.SliceStable(keys, func(i, j int) bool {
+		return lessValue(keys[i], keys[j])
+	})
+}
+
+// lessValue orders map keys. Keys of different kinds, which maps with interface keys may hold,
+// are ordered by kind, and keys of kinds without a natural order by their text.
+func lessValue(a reflect.Value, b reflect.Value) bool {
+	for a.Kind() == reflect.Interface {
+		a = a.Elem()
+	}
+	for b.Kind() == reflect.Interface {
+		b = b.Elem()
+	}
+	if a.Kind() != b.Kind() {
+		return a.Kind() < b.Kind()
+	}
+	switch a.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return a.Int() < b.Int()
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return a.Uint() < b.Uint()
+	case reflect.Float32, reflect.Float64:
+		return a.Float() < b.Float()
+	case reflect.String:
+		return a.String() < b.String()
+	case reflect.Bool:
+		return !a.Bool() && b.Bool()
+	}
+	return fmt.Sprint(elem(a)) < fmt.Sprint(elem(b))
+}
+
+// elem returns the value that a loop stores in the scope for an element, or nil if it cannot
+// be read.
+func elem(v reflect.Value) interface{} {
+	if v.IsValid() && v.CanInterface() {
+		return v.Interface()
+	}
+	return nil
+}
+
+func (self *assign) exec(st *state) {
+	st.set(self.name, self.value, self.define)
+}
+
+func (self *vassign) exec(st *state) {
+	st.set(self.name, self.value.stored(st), self.define)
+}
+
+func (self *slot) exec(st *state) {
+	if content, ok := st.blocks[self.name]; ok {
+		st.write(reindent(content, self.indent))
+	} else {
+		self.body.exec(st)
+	}
+}
+
+func (self *define) exec(st *state) {
+	if _, ok := st.blocks[self.name]; !ok {
+		st.blocks[self.name] = self.body.capture(st)
+	}
+}
+
+func (self *yield) exec(st *state) {
+	if st.yield == nil {
+		st.write(self.value.resolve(st))
+		return
+	}
+	st.write(reindent(*st.yield, self.indent))
+}
+
+func (self *capture) exec(st *state) {
+	text := self.body.capture(st)
+	if old, ok := st.slots[self.name]; ok {
+		text = old + "\n" + text
+	}
+	st.slots[self.name] = text
+}
+
+func (self *content) exec(st *state) {
+	st.write(reindent(st.slots[self.name], self.indent))
+}
+
+func (self *call) exec(st *state) {
+	if !st.enter("mixin", self.mixin.name) {
+		return
+	}
+	defer st.leave()
+	scope := make(map[string]interface{}, len(self.args))
+	for i, arg := range self.args {
+		scope[self.mixin.params[i]] = arg.interfaceValue(st)
+	}
+	outerScope, outerRoot, outerYield := st.scope, st.root, st.yield
+	block := self.block.capture(st)
+	st.yield = &block
+	st.scope, st.root = scope, reflect.Value{}
+	mark := len(st.shadowed)
+	st.write(reindent(self.mixin.body.capture(st), self.indent))
+	st.unwind(mark)
+	st.scope, st.root, st.yield = outerScope, outerRoot, outerYield
+}
+
+func (self *partial) exec(st *state) {
+	engine := st.load("partial", self.name)
+	if engine == nil {
+		return
+	}
+	values := make([]interface{}, len(self.values))
+	for i, value := range self.values {
+		values[i] = value.interfaceValue(st)
+	}
+	locals := func() map[string]interface{} {
+		scope := make(map[string]interface{}, len(values)+2)
+		for i, name := range self.locals {
+			scope[name] = values[i]
+		}
+		return scope
+	}
+	if !self.each {
+		self.render(st, engine, locals())
+		return
+	}
+	t := self.collection.resolveValue(st)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		t = t.Elem()
+	}
+	if (t.Kind() != reflect.Slice && t.Kind() != reflect.Array) || !t.CanInterface() {
+		st.fail(fmt.Errorf("gohaml: cannot render %s for each element of %s", self.name, self.collection.value))
+		return
+	}
+	parent, _ := st.scope["loop"].(*Loop)
+	for i := 0; i < t.Len() && st.iterate(); i++ {
+		if i > 0 {
+			st.write("\n" + self.indent)
+		}
+		scope := locals()
+		scope[self.as] = t.Index(i).Interface()
+		scope[self.as+"_index"] = i
+		scope["loop"] = newLoop(i, t.Len(), i == t.Len()-1, parent)
+		self.render(st, engine, scope)
+	}
+}
+
+func (self *partial) render(st *state, engine *Engine, scope map[string]interface{}) {
+	if !st.enter("partial", self.name) {
+		return
+	}
+	defer st.leave()
+	outer := st.buf
+	var buf bytes.Buffer
+	root := st.root
+	st.scope, scope, st.root = scope, st.scope, reflect.Value{}
+	st.buf, st.base = &buf, st.base+outer.Len()
+	mark := len(st.shadowed)
+	engine.execute(st)
+	st.unwind(mark)
+	st.scope, st.root, st.buf, st.base = scope, root, outer, st.base-outer.Len()
+	st.write(reindent(buf.String(), self.indent))
+}
+
+// reindent indents every line of content but the first by indent, so that content rendered on
+// its own lines up with the place it is written to.
+func reindent(content string, indent string) string {
+	if len(indent) == 0 {
+		return content
+	}
+	return strings.Replace(content, "\n", "\n"+indent, -1)
+}
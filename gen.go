@@ -0,0 +1,812 @@
+package gohaml
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"go/types"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const gohamlPath = "github.com/realistschuckle/gohaml"
+
+// local is a variable of the generated code that holds the value of a name of the template.
+// used tells whether the template refers to it.
+type local struct {
+	ident string
+	typ   types.Type
+	used  bool
+}
+
+type generator struct {
+	out     *bytes.Buffer
+	pkg     *types.Package
+	data    types.Type
+	imports map[string]string
+	scopes  []map[string]*local
+	vars    int
+	loops   []*loopFrame
+	skips   bool
+}
+
+// loopFrame holds what the branches in the body of a loop need: the separator of the loop, the
+// condition that holds unless the element is the last one, and the variable holding the length
+// of the buffer before the loop. label and next are the labels of the loop and of the statement
+// that moves on to the next element, which are only written if a branch uses them.
+type loopFrame struct {
+	sep, more, start  string
+	label, next       string
+	breaks, continues bool
+}
+
+// loopType is *Loop as seen by go/types.
+var loopType = func() types.Type {
+	pkg := types.NewPackage(gohamlPath, "gohaml")
+	named := types.NewNamed(types.NewTypeName(token.NoPos, pkg, "Loop", nil), nil, nil)
+	ptr := types.NewPointer(named)
+	var fields []*types.Var
+	for _, name := range []string{"Index", "Index1", "First", "Last", "Length", "Odd", "Even", "Parent"} {
+		var typ types.Type = types.Typ[types.Int]
+		switch name {
+		case "First", "Last", "Odd", "Even":
+			typ = types.Typ[types.Bool]
+		case "Parent":
+			typ = ptr
+		}
+		fields = append(fields, types.NewField(token.NoPos, pkg, name, typ, false))
+	}
+	named.SetUnderlying(types.NewStruct(fields, nil))
+	return ptr
+}()
+
+// GenerateGo writes the source of a Go file in package pkg that declares a function
+//
+//	func name(w io.Writer, data *T) error
+//
+// where T is the type given as data. The function writes the markup that Render would produce
+// with the current Indentation and Autoclose settings for a scope holding the fields of data, or
+// its entries if T is a map with string keys, but it accesses fields directly instead of by
+// reflection and writes everything that does not depend on data as precomputed strings.
+//
+// Paths are resolved against the static types of the fields, so a path that names a missing
+// field or that goes through an interface value is an error. A nil pointer along a path renders
+// as an empty value. Names assigned inside a range body are only visible inside that body.
+func (self *Engine) GenerateGo(w io.Writer, pkg *types.Package, name string, data types.Type) (err error) {
+	if self.ast.extends != "" {
+		return fmt.Errorf("gohaml: cannot generate code for a template that extends a layout")
+	}
+	g := &generator{out: new(bytes.Buffer), pkg: pkg, data: data, imports: map[string]string{"bytes": "bytes", "io": "io"}}
+	g.push()
+	if err = g.program(self.program(self.Indentation, self.Autoclose).program); err != nil {
+		return
+	}
+
+	var file bytes.Buffer
+	fmt.Fprintf(&file, "// Code generated by gohaml. DO NOT EDIT.\n\npackage %s\n\nimport (\n", pkg.Name())
+	var paths []string
+	for path := range g.imports {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		fmt.Fprintf(&file, "\t%s %q\n", g.imports[path], path)
+	}
+	fmt.Fprintf(&file, ")\n\n// %s renders its template with the fields of data as the scope.\n", name)
+	fmt.Fprintf(&file, "func %s(w io.Writer, data %s) error {\n", name, g.typeString(types.NewPointer(data)))
+	file.WriteString("var buf bytes.Buffer\n")
+	if g.skips {
+		file.WriteString("skip := false\n")
+	}
+	file.Write(g.out.Bytes())
+	file.WriteString("_, err := w.Write(buf.Bytes())\nreturn err\n}\n")
+
+	var src []byte
+	if src, err = format.Source(file.Bytes()); err != nil {
+		return
+	}
+	_, err = w.Write(src)
+	return
+}
+
+func (self *generator) printf(format string, args ...interface{}) {
+	fmt.Fprintf(self.out, format, args...)
+}
+
+func (self *generator) use(path string, name string) string {
+	self.imports[path] = name
+	return name
+}
+
+func (self *generator) newVar(base string) string {
+	self.vars++
+	return fmt.Sprintf("_%s%d", base, self.vars)
+}
+
+func (self *generator) push() {
+	self.scopes = append(self.scopes, make(map[string]*local))
+}
+
+func (self *generator) pop() {
+	self.scopes = self.scopes[:len(self.scopes)-1]
+}
+
+func (self *generator) declare(name string, typ types.Type) (ident string) {
+	return self.declareLocal(name, typ).ident
+}
+
+func (self *generator) declareLocal(name string, typ types.Type) (l *local) {
+	l = &local{ident: self.newVar(name), typ: typ}
+	self.scopes[len(self.scopes)-1][name] = l
+	return
+}
+
+// local returns the innermost local for name and marks it as used.
+func (self *generator) local(name string) (l *local, ok bool) {
+	for i := len(self.scopes) - 1; i >= 0; i-- {
+		if l, ok = self.scopes[i][name]; ok {
+			l.used = true
+			return
+		}
+	}
+	return
+}
+
+func (self *generator) typeString(t types.Type) string {
+	return types.TypeString(t, func(other *types.Package) string {
+		if other == self.pkg {
+			return ""
+		}
+		return self.use(other.Path(), other.Name())
+	})
+}
+
+func (self *generator) program(p program) (err error) {
+	for _, i := range p {
+		if err = self.instr(i); err != nil {
+			return
+		}
+	}
+	return
+}
+
+func (self *generator) instr(i instr) (err error) {
+	switch i := i.(type) {
+	case chunk:
+		self.printf("buf.WriteString(%q)\n", string(i))
+	case *hole:
+		var s string
+		if s, err = self.format(i.value); err == nil {
+			self.printf("buf.WriteString(%s)\n", s)
+		}
+	case *yield:
+		// Generated code renders no layouts or mixins, so nothing ever yields to it.
+		var s string
+		if s, err = self.format(i.value); err == nil {
+			self.printf("buf.WriteString(%s)\n", s)
+		}
+	case *attrs:
+		var pairs []string
+		for _, pair := range i.pairs {
+			var key, value string
+			if key, err = self.format(pair.key); err != nil {
+				return
+			}
+			if value, err = self.format(pair.value); err != nil {
+				return
+			}
+			pairs = append(pairs, key, value)
+		}
+		self.printf("%s.WriteAttrs(&buf", self.use(gohamlPath, "gohaml"))
+		for _, s := range pairs {
+			self.printf(", %s", s)
+		}
+		self.printf(")\n")
+	case *inline:
+		var s string
+		if s, err = self.format(i.value); err == nil {
+			self.printf("if %s != \"\" {\nbuf.WriteString(\">\")\nbuf.WriteString(%s)\nbuf.WriteString(%q)\n} else {\nbuf.WriteString(%q)\n}\n", s, s, i.close, i.empty)
+		}
+	case *loop:
+		err = self.loop(i)
+	case *choice:
+		err = self.choice(i)
+	case branch:
+		self.branch(i)
+	case *optional:
+		err = self.optional(i)
+	case separator:
+		self.printf("if skip {\nskip = false\n} else {\nbuf.WriteString(%q)\n}\n", string(i))
+	case *assign:
+		var typ types.Type
+		var value string
+		if value, typ, err = literal(i.value); err == nil {
+			err = self.store(i.name, typ, value, i.define)
+		}
+	case *vassign:
+		var s string
+		var typ types.Type
+		if i.value.comp != nil {
+			s, typ, err = self.composite(i.value.comp)
+		} else if i.value.pipe != nil {
+			s, err = self.pipe(i.value.pipe)
+			typ = emptyInterface
+		} else if s, err = self.format(i.value); err == nil {
+			typ = types.Typ[types.String]
+		}
+		if err == nil {
+			err = self.store(i.name, typ, s, i.define)
+		}
+	default:
+		err = fmt.Errorf("gohaml: cannot generate code for %T", i)
+	}
+	return
+}
+
+// emptyInterface is interface{} as seen by go/types.
+var emptyInterface = types.NewInterfaceType(nil, nil)
+
+// literal returns a Go expression for the literal value v and its type.
+func literal(v interface{}) (expr string, typ types.Type, err error) {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v), types.Typ[types.String], nil
+	case int:
+		return strconv.Itoa(v), types.Typ[types.Int], nil
+	case float64:
+		return fmt.Sprintf("float64(%s)", strconv.FormatFloat(v, 'g', -1, 64)), types.Typ[types.Float64], nil
+	case bool:
+		return strconv.FormatBool(v), types.Typ[types.Bool], nil
+	case rune:
+		return strconv.QuoteRune(v), types.Typ[types.Int32], nil
+	case nil:
+		return "interface{}(nil)", emptyInterface, nil
+	}
+	return "", nil, fmt.Errorf("gohaml: cannot generate a literal of %T", v)
+}
+
+// composite writes the statements that make the slice or the map of c like its evaluate method
+// does, and returns the variable holding it and its type.
+func (self *generator) composite(c *composite) (v string, typ types.Type, err error) {
+	values := make([]string, len(c.values))
+	for i, r := range c.values {
+		if values[i], err = self.value(r); err != nil {
+			return
+		}
+	}
+	if !c.isMap {
+		v, typ = self.newVar("slice"), types.NewSlice(emptyInterface)
+		self.printf("%s := []interface{}{%s}\n", v, strings.Join(values, ", "))
+		return
+	}
+	keyType := types.Type(emptyInterface)
+	if c.stringKeys {
+		keyType = types.Typ[types.String]
+	}
+	v, typ = self.newVar("map"), types.NewMap(keyType, emptyInterface)
+	self.printf("%s := make(%s, %d)\n", v, self.typeString(typ), len(c.keys))
+	for i, k := range c.keys {
+		var key string
+		if key, err = self.value(k); err != nil {
+			return
+		}
+		self.printf("%s[%s] = %s\n", v, key, values[i])
+	}
+	return
+}
+
+// store declares a local for name holding the value of expr, or if define is false, assigns the
+// value to the local declared before, which has to be of the same type.
+func (self *generator) store(name string, typ types.Type, expr string, define bool) error {
+	if define {
+		ident := self.declare(name, typ)
+		self.printf("%s := %s\n_ = %s\n", ident, expr, ident)
+		return nil
+	}
+	l, ok := self.local(name)
+	if !ok {
+		return fmt.Errorf("gohaml: cannot generate an assignment to %s, which is not declared in the template", name)
+	}
+	if !types.Identical(l.typ, typ) {
+		return fmt.Errorf("gohaml: cannot assign a value of type %s to %s of type %s", typ, name, l.typ)
+	}
+	self.printf("%s = %s\n", l.ident, expr)
+	return nil
+}
+
+// block writes the code for p, whose locals are gone after it.
+func (self *generator) block(p program) (err error) {
+	self.push()
+	err = self.program(p)
+	self.pop()
+	return
+}
+
+func (self *generator) loop(l *loop) (err error) {
+	var x string
+	var typ types.Type
+	var depth int
+	// The count is declared outside of the blocks that guard the lookup against nil pointers,
+	// since the else branch of the loop runs if a pointer is nil as well.
+	count := self.newVar("n")
+	self.printf("%s := 0\n", count)
+	if l.x.comp != nil {
+		if x, typ, err = self.composite(l.x.comp); err != nil {
+			return
+		}
+	} else if l.x.pipe != nil {
+		return fmt.Errorf("gohaml: cannot range over %s, whose type is not known until it is rendered", l.x.value)
+	} else if !l.x.needsResolution {
+		switch v := l.x.lit.(type) {
+		case int:
+			x, typ = strconv.Itoa(v), types.Typ[types.Int]
+		case string:
+			x, typ = strconv.Quote(v), types.Typ[types.String]
+		default:
+			return fmt.Errorf("gohaml: cannot range over %s", l.x.value)
+		}
+	} else if x, typ, depth, err = self.lookup(l.x); err != nil {
+		return
+	}
+
+	// length is the number of elements, which a channel does not tell in advance.
+	keyName, valueName := l.key, l.value
+	var keyType, valueType types.Type
+	var length string
+	rangeVar := self.newVar("x")
+	switch u := typ.Underlying().(type) {
+	case *types.Slice:
+		keyType, valueType, length = types.Typ[types.Int], u.Elem(), "len("+rangeVar+")"
+	case *types.Array:
+		keyType, valueType, length = types.Typ[types.Int], u.Elem(), "len("+rangeVar+")"
+	case *types.Map:
+		keyType, valueType, length = u.Key(), u.Elem(), "len("+rangeVar+")"
+		if b, ok := keyType.Underlying().(*types.Basic); !ok || b.Info()&(types.IsOrdered|types.IsBoolean) == 0 {
+			return fmt.Errorf("gohaml: cannot generate code to range over %s in the order of its keys of type %s", l.x.value, keyType)
+		}
+	case *types.Chan:
+		if u.Dir() == types.SendOnly {
+			return fmt.Errorf("gohaml: cannot range over %s of type %s", l.x.value, typ)
+		}
+		keyName, valueName, valueType = "", keyName, u.Elem()
+	case *types.Basic:
+		switch {
+		case u.Info()&types.IsString != 0:
+			keyType, valueType = types.Typ[types.Int], types.Typ[types.String]
+			length = self.use("unicode/utf8", "utf8") + ".RuneCountInString(" + rangeVar + ")"
+		case u.Info()&types.IsInteger != 0:
+			keyType, length = typ, "int("+rangeVar+")"
+		default:
+			return fmt.Errorf("gohaml: cannot range over %s of type %s", l.x.value, typ)
+		}
+	default:
+		return fmt.Errorf("gohaml: cannot range over %s of type %s", l.x.value, typ)
+	}
+	if (keyType == nil || valueType == nil) && l.value != "" {
+		return fmt.Errorf("gohaml: range over %s permits only one iteration variable", l.x.value)
+	}
+
+	self.printf("%s := %s\n", rangeVar, x)
+	self.push()
+	key, value := "_", "_"
+	if keyName != "" && keyName != "_" {
+		key = self.declare(keyName, keyType)
+	}
+	if valueName != "" && valueName != "_" {
+		value = self.declare(valueName, valueType)
+	}
+	meta := self.declareLocal("loop", loopType)
+
+	// The body is generated first to find out whether it refers to loop and needs the length.
+	var lengthVar, more string
+	if length != "" {
+		lengthVar = self.newVar("l")
+		more = fmt.Sprintf("%s != %s-1", count, lengthVar)
+	} else {
+		more = self.newVar("more")
+	}
+	needsLength := false
+	frame := &loopFrame{sep: l.sep, more: more, start: self.newVar("start"), label: self.newVar("L"), next: self.newVar("next")}
+	self.loops = append(self.loops, frame)
+	outer := self.out
+	self.out = new(bytes.Buffer)
+	for i, child := range l.children {
+		if err = self.program(child.body); err != nil {
+			return
+		}
+		if n := len(child.body); n > 0 && isBranch(child.body[n-1]) {
+			// The children after a branch are never rendered.
+			break
+		}
+		if child.sep && lastOptional(child.body) != nil {
+			// The separator is left out if the optional child wrote nothing.
+			self.printf("if skip {\nskip = false\n} else ")
+			if i < l.last {
+				self.printf("{\nbuf.WriteString(%q)\n}\n", l.sep)
+				continue
+			}
+		}
+		if child.sep && i < l.last {
+			self.printf("buf.WriteString(%q)\n", l.sep)
+		} else if child.sep {
+			self.printf("if %s {\nbuf.WriteString(%q)\n}\n", more, l.sep)
+			needsLength = true
+		}
+	}
+	body := self.out
+	self.out = outer
+	self.pop()
+	self.loops = self.loops[:len(self.loops)-1]
+	needsLength = needsLength || frame.continues
+
+	last, size := "!"+more, "-1"
+	if length != "" {
+		last, size = fmt.Sprintf("%s == %s-1", count, lengthVar), lengthVar
+		if needsLength || meta.used {
+			self.printf("%s := %s\n", lengthVar, length)
+		}
+	}
+	if frame.breaks || frame.continues {
+		self.printf("%s := buf.Len()\n", frame.start)
+	}
+	// The label of the loop has to come right before the for statement.
+	var label string
+	if frame.breaks {
+		label = frame.label + ":\n"
+	}
+	var footer string
+	switch u := typ.Underlying().(type) {
+	case *types.Chan:
+		if value == "_" {
+			value = self.newVar("v")
+		}
+		ok, next := self.newVar("ok"), self.newVar("next")
+		self.printf("var %s %s\n%s := %s != nil\nif %s {\n%s, %s = <-%s\n}\n", value, self.typeString(valueType), ok, rangeVar, ok, value, ok, rangeVar)
+		self.printf("%sfor %s {\n%s, %s := <-%s\n", label, ok, next, more, rangeVar)
+		footer = fmt.Sprintf("%s, %s = %s, %s\n", value, ok, next, more)
+	case *types.Map:
+		if key == "_" {
+			key = self.newVar("k")
+		}
+		keys, k := self.newVar("keys"), self.newVar("k")
+		less := "%s[i] < %s[j]"
+		if u.Key().Underlying().(*types.Basic).Info()&types.IsBoolean != 0 {
+			less = "!%s[i] && %s[j]"
+		}
+		self.printf("%s := make([]%s, 0, len(%s))\nfor %s := range %s {\n%s = append(%s, %s)\n}\n",
+			keys, self.typeString(keyType), rangeVar, k, rangeVar, keys, keys, k)
+		self.printf("%s.Slice(%s, func(i, j int) bool {\nreturn "+less+"\n})\n", self.use("sort", "sort"), keys, keys, keys)
+		self.printf("%sfor _, %s := range %s {\n", label, key, keys)
+		if value != "_" {
+			self.printf("%s := %s[%s]\n", value, rangeVar, key)
+		}
+	case *types.Basic:
+		if u.Info()&types.IsInteger != 0 {
+			if key == "_" {
+				key = self.newVar("i")
+			}
+			self.printf("%sfor %s := %s(0); %s < %s; %s++ {\n", label, key, self.typeString(keyType), key, rangeVar, key)
+		} else if value == "_" {
+			self.out.WriteString(label + rangeClause(key, value, rangeVar))
+		} else {
+			r := self.newVar("r")
+			self.out.WriteString(label + rangeClause(key, r, rangeVar))
+			self.printf("%s := string(%s)\n", value, r)
+		}
+	default:
+		self.out.WriteString(label + rangeClause(key, value, rangeVar))
+	}
+	for _, ident := range []string{key, value} {
+		if ident != "_" {
+			self.printf("_ = %s\n", ident)
+		}
+	}
+	if meta.used {
+		parent := "nil"
+		if p, ok := self.local("loop"); ok && p.typ == loopType {
+			parent = p.ident
+		}
+		self.printf("%s := &%s.Loop{Index: %s, Index1: %s + 1, First: %s == 0, Last: %s, Length: %s, Odd: %s%%2 == 0, Even: %s%%2 == 1, Parent: %s}\n",
+			meta.ident, self.use(gohamlPath, "gohaml"), count, count, count, last, size, count, count, parent)
+	}
+	if frame.continues {
+		self.printf("{\n%s}\n%s:\n", body.Bytes(), frame.next)
+	} else {
+		self.out.Write(body.Bytes())
+	}
+	self.printf("%s++\n%s}\n", count, footer)
+	self.close(depth)
+	if l.otherwise != nil {
+		self.printf("if %s == 0 {\n", count)
+		if err = self.block(l.otherwise); err != nil {
+			return
+		}
+		self.printf("}\n")
+	}
+	return
+}
+
+func isBranch(i instr) bool {
+	_, ok := i.(branch)
+	return ok
+}
+
+// optional writes the code for o like its exec method runs it.
+func (self *generator) optional(o *optional) (err error) {
+	start := self.newVar("start")
+	self.printf("%s := buf.Len()\n", start)
+	if err = self.program(o.body); err != nil {
+		return
+	}
+	self.printf("if buf.Len() == %s {\nif _n := %s - %d; _n >= 0 && %s.HasSuffix(buf.Bytes(), []byte(%q)) {\nbuf.Truncate(_n)\n}",
+		start, start, len(o.sep), self.use("bytes", "bytes"), o.sep)
+	if o.after {
+		self.skips = true
+		self.printf(" else {\nskip = true\n}")
+	}
+	self.printf("\n}\n")
+	return
+}
+
+// branch writes a break or a continue for the innermost loop, removing the separator that the
+// loop wrote last like the trim method of loop does.
+func (self *generator) branch(b branch) {
+	frame := self.loops[len(self.loops)-1]
+	trim := fmt.Sprintf("if _n := buf.Len() - %d; _n >= %s && %s.HasSuffix(buf.Bytes(), []byte(%q)) {\nbuf.Truncate(_n)\n}\n",
+		len(frame.sep), frame.start, self.use("bytes", "bytes"), frame.sep)
+	if flow(b) == flowBreak {
+		frame.breaks = true
+		self.printf("%sbreak %s\n", trim, frame.label)
+		return
+	}
+	frame.continues = true
+	self.printf("if !(%s) {\n%s}\ngoto %s\n", frame.more, trim, frame.next)
+}
+
+// rangeClause returns the clause of a for statement that ranges over x, leaving out the blank
+// iteration variables.
+func rangeClause(key string, value string, x string) string {
+	switch {
+	case key == "_" && value == "_":
+		return fmt.Sprintf("for range %s {\n", x)
+	case value == "_":
+		return fmt.Sprintf("for %s := range %s {\n", key, x)
+	}
+	return fmt.Sprintf("for %s, %s := range %s {\n", key, value, x)
+}
+
+// lookup writes the statements that find the value of the path r. It returns the expression
+// holding the value, the type of the value and the number of blocks that were opened to guard
+// against nil pointers along the path.
+func (self *generator) lookup(r res) (expr string, typ types.Type, depth int, err error) {
+	names := r.path
+	if l, ok := self.local(names[0]); ok {
+		expr, typ, names = l.ident, l.typ, names[1:]
+	} else {
+		expr, typ = "data", self.data
+		if _, ok := self.data.Underlying().(*types.Map); ok {
+			expr = "(*data)"
+		}
+		// Like Render, methods without parameters of the data are called for their value.
+		obj, _, _ := types.LookupFieldOrMethod(typ, true, self.pkg, names[0])
+		if f, ok := obj.(*types.Func); ok {
+			if sig := f.Type().(*types.Signature); sig.Params().Len() == 0 && sig.Results().Len() == 1 {
+				expr, typ, names = expr+"."+names[0]+"()", sig.Results().At(0).Type(), names[1:]
+			}
+		}
+	}
+	for _, name := range names {
+		for {
+			p, ok := typ.Underlying().(*types.Pointer)
+			if !ok {
+				break
+			}
+			ptr := self.newVar("p")
+			self.printf("if %s := %s; %s != nil {\n", ptr, expr, ptr)
+			depth++
+			expr, typ = "(*"+ptr+")", p.Elem()
+		}
+		switch u := typ.Underlying().(type) {
+		case *types.Struct:
+			obj, _, _ := types.LookupFieldOrMethod(typ, false, self.pkg, name)
+			field, ok := obj.(*types.Var)
+			if !ok || !field.IsField() {
+				err = fmt.Errorf("gohaml: %s: %s has no field %s", r.value, typ, name)
+				return
+			}
+			expr, typ = expr+"."+name, field.Type()
+		case *types.Map:
+			if b, ok := u.Key().Underlying().(*types.Basic); !ok || b.Info()&types.IsString == 0 {
+				err = fmt.Errorf("gohaml: %s: cannot look %s up in %s", r.value, name, typ)
+				return
+			}
+			expr, typ = fmt.Sprintf("%s[%q]", expr, name), u.Elem()
+		default:
+			err = fmt.Errorf("gohaml: %s: cannot look %s up in %s", r.value, name, typ)
+			return
+		}
+	}
+	return
+}
+
+// choice writes a switch statement that compares the values with Equal, like the choice
+// instruction does.
+func (self *generator) choice(c *choice) (err error) {
+	var x string
+	if x, err = self.value(c.x); err != nil {
+		return
+	}
+	var conds []string
+	for _, cc := range c.cases {
+		var values []string
+		for _, r := range cc.values {
+			var v string
+			if v, err = self.value(r); err != nil {
+				return
+			}
+			values = append(values, fmt.Sprintf("%s.Equal(%s, %s)", self.use(gohamlPath, "gohaml"), x, v))
+		}
+		conds = append(conds, strings.Join(values, " || "))
+	}
+	self.printf("switch {\n")
+	for i, cc := range c.cases {
+		self.printf("case %s:\n", conds[i])
+		if err = self.block(cc.body); err != nil {
+			return
+		}
+	}
+	if c.otherwise != nil {
+		self.printf("default:\n")
+		if err = self.block(c.otherwise); err != nil {
+			return
+		}
+	}
+	self.printf("}\n")
+	return
+}
+
+// value returns an expression holding the value of r as an interface{}, writing the statements
+// that compute it first. Like resolveValue, it leaves the value nil if a pointer along the path
+// is nil.
+func (self *generator) value(r res) (v string, err error) {
+	if !r.needsResolution {
+		v, _, err = literal(r.lit)
+		return
+	}
+	if r.comp != nil {
+		v, _, err = self.composite(r.comp)
+		return
+	}
+	if r.pipe != nil {
+		return self.pipe(r.pipe)
+	}
+	var expr string
+	var depth int
+	v = self.newVar("v")
+	self.printf("var %s interface{}\n", v)
+	if expr, _, depth, err = self.lookup(r); err != nil {
+		return
+	}
+	self.printf("%s = %s\n", v, expr)
+	self.close(depth)
+	return
+}
+
+// pipe writes the statements that pass the value of p through its helpers with Pipe, returning
+// the error of a helper from the generated function. It returns the variable holding the result.
+// Only the helpers built in can be used.
+func (self *generator) pipe(p *pipe) (v string, err error) {
+	if v, err = self.value(p.x); err != nil {
+		return
+	}
+	for _, s := range p.stages {
+		if _, ok := helpers[s.name]; !ok {
+			return "", fmt.Errorf("gohaml: cannot generate code for helper %s, which is not built in", s.name)
+		}
+		args := []string{strconv.Quote(s.name), v}
+		for _, arg := range s.args {
+			var a string
+			if a, err = self.value(arg); err != nil {
+				return
+			}
+			args = append(args, a)
+		}
+		e := self.newVar("e")
+		v = self.newVar("v")
+		self.printf("%s, %s := %s.Pipe(%s)\n", v, e, self.use(gohamlPath, "gohaml"), strings.Join(args, ", "))
+		self.printf("if %s != nil {\nreturn %s\n}\n", e, e)
+	}
+	return
+}
+
+// format returns an expression holding the text that Render writes for r, writing the
+// statements that compute it first.
+func (self *generator) format(r res) (s string, err error) {
+	if !r.needsResolution {
+		return strconv.Quote(r.value), nil
+	}
+	var expr string
+	var typ types.Type
+	var depth int
+	if r.comp != nil {
+		if expr, typ, err = self.composite(r.comp); err != nil {
+			return
+		}
+	}
+	if r.pipe != nil {
+		if expr, err = self.pipe(r.pipe); err != nil {
+			return
+		}
+		typ = emptyInterface
+	}
+	s = self.newVar("s")
+	self.printf("%s := \"\"\n", s)
+	if r.comp == nil && r.pipe == nil {
+		if expr, typ, depth, err = self.lookup(r); err != nil {
+			return
+		}
+	}
+	depth += self.formatTo(s, expr, typ)
+	self.close(depth)
+	return
+}
+
+// formatTo writes the statements that store the text of expr, which is of type typ, in s the way
+// Format would, dereferencing pointers. Values of basic types without methods are formatted
+// directly; all others are left to Format. It returns the number of blocks it opened.
+func (self *generator) formatTo(s string, expr string, typ types.Type) (depth int) {
+	for {
+		if types.NewMethodSet(typ).Len() > 0 {
+			self.printf("%s = %s.Format(%s)\n", s, self.use(gohamlPath, "gohaml"), expr)
+			return
+		}
+		switch u := typ.Underlying().(type) {
+		case *types.Basic:
+			info := u.Info()
+			switch {
+			case info&types.IsString != 0 && types.Identical(typ, types.Typ[types.String]):
+				self.printf("%s = %s\n", s, expr)
+			case info&types.IsString != 0:
+				self.printf("%s = string(%s)\n", s, expr)
+			case info&types.IsUnsigned != 0:
+				self.printf("%s = %s.FormatUint(uint64(%s), 10)\n", s, self.use("strconv", "strconv"), expr)
+			case info&types.IsInteger != 0:
+				self.printf("%s = %s.FormatInt(int64(%s), 10)\n", s, self.use("strconv", "strconv"), expr)
+			case info&types.IsFloat != 0:
+				bits := 64
+				if u.Kind() == types.Float32 {
+					bits = 32
+				}
+				self.printf("%s = %s.FormatFloat(float64(%s), 'g', -1, %d)\n", s, self.use("strconv", "strconv"), expr, bits)
+			case info&types.IsComplex != 0:
+				bits := 128
+				if u.Kind() == types.Complex64 {
+					bits = 64
+				}
+				self.printf("%s = %s.FormatComplex(complex128(%s), 'g', -1, %d)\n", s, self.use("strconv", "strconv"), expr, bits)
+			case info&types.IsBoolean != 0:
+				self.printf("%s = %s.FormatBool(bool(%s))\n", s, self.use("strconv", "strconv"), expr)
+			default:
+				self.printf("%s = %s.Format(%s)\n", s, self.use(gohamlPath, "gohaml"), expr)
+			}
+		case *types.Pointer:
+			ptr := self.newVar("p")
+			self.printf("if %s := %s; %s != nil {\n", ptr, expr, ptr)
+			depth++
+			expr, typ = "*"+ptr, u.Elem()
+			continue
+		default:
+			self.printf("%s = %s.Format(%s)\n", s, self.use(gohamlPath, "gohaml"), expr)
+		}
+		return
+	}
+}
+
+func (self *generator) close(depth int) {
+	for ; depth > 0; depth-- {
+		self.printf("}\n")
+	}
+}
@@ -1,14 +1,28 @@
 // The gohaml package contains a HAML parser similar to the one found at http://www.haml-lang.com.
 //
-//You can find the specifics about this implementation at http://github.com/realistschuckle/gohaml.
+// You can find the specifics about this implementation at http://github.com/realistschuckle/gohaml.
 package gohaml
 
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/realistschuckle/gohaml/ast"
+)
+
 /*
 Engine provides the template interpretation functionality to convert a HAML template into its
 corresponding tag-based representation.
 
 Available options are:
-  engine.Options["autoclose"] = true|false, default true
+
+	engine.Options["autoclose"] = true|false, default true
 
 The Options field contains the values to modify the way that the engine produces the markup.
 
@@ -16,26 +30,241 @@ The Indentation field contains the string used by the engine to perform indentat
 
 The IncludeCallback field contains the callback invoked by the gohaml engine to process other files
 included through the %include extension.
+
+The Loader field contains the Loader that loads the layouts named by "- extends". Engines returned
+by the file system loader have it set to that loader.
+
+The KeyLess field, if set, orders the keys of the maps that the template ranges over, for the
+layouts and partials it renders as well. By default numbers are ordered numerically, strings
+lexically and false before true, like text/template does. Code written by GenerateGo always uses
+the default order.
+
+The Formatters field maps types to the functions that write their values instead of Format, for
+the layouts and partials the engine renders as well. Code written by GenerateGo always uses
+Format.
+
+The Helpers field holds the functions that the stages of a pipe, like "= post.Body | truncate 140",
+name in addition to the helpers built in: escape, upcase, downcase, strip, truncate, default and
+join. A helper is called with the value before it followed by the arguments of the stage, and
+returns a value and optionally an error. The helpers of the field take precedence over built-in
+ones of the same name, for the layouts and partials the engine renders as well. Code written by
+GenerateGo can only use the built-in helpers.
+
+The MaxIterations, MaxDepth, MaxOutput and Timeout fields limit what a render of the engine may
+do, including the layouts and partials it renders: the number of loop iterations in all, how
+deeply mixins and partials may be nested within each other, the number of bytes written,
+counting markup captured for blocks and slots, and the time it may take. A render that goes
+beyond a limit stops and Execute returns an error saying which; zero means no limit, except that
+mixins and partials are never nested more than 1000 deep, so that one that renders itself fails
+the render instead of crashing the program. Code written by GenerateGo has no limits.
+
+The Globals field holds variables that every render of the engine sees beneath its scope, like the
+name of the site or the version of the build, for the layouts, partials and mixins it renders as
+well. The scope and the variables declared by the template shadow globals of the same name.
+Renders only read the map, so it must not be changed while the engine renders. Engines loaded by
+a Loader returned by WithGlobals have it set. Code written by GenerateGo ignores globals.
 */
 type Engine struct {
 	Autoclose       bool
 	Indentation     string
 	IncludeCallback func(string, map[string]interface{}) string
+	Loader          Loader
+	KeyLess         func(a, b interface{}) bool
+	Formatters      map[reflect.Type]func(v interface{}) string
+	Helpers         map[string]interface{}
+	Globals         map[string]interface{}
+	MaxIterations   int
+	MaxDepth        int
+	MaxOutput       int
+	Timeout         time.Duration
 	ast             *tree
+	cache           *cache
 }
 
-// NewEngine returns a new Engine with the given input.
+// cache holds the program for the Indentation and Autoclose settings it was compiled with, so
+// that only the first Render after a change of the settings compiles the tree again. An engine
+// and its clones share it.
+type cache struct {
+	sync.Mutex
+	compiled *compiled
+}
+
+type compiled struct {
+	indent    string
+	autoclose bool
+	program   program
+	size      int
+}
+
+// NewEngine returns a new Engine with the given input. If the input contains syntax errors,
+// err describes the first of them.
 func NewEngine(input string) (engine *Engine, err error) {
-	var output *tree
-	output, err = parser.parse(input)
+	if engine, err = NewEngineAll(input, 1); err != nil {
+		err = err.(ErrorList)[0]
+	}
+	return
+}
+
+// NewEngineAll returns a new Engine with the given input like NewEngine does, but it keeps
+// parsing after a syntax error so that every mistake in the template can be fixed at once. On
+// failure err is an ErrorList, sorted by position, holding at most maxErrors errors; a maxErrors
+// of zero or less collects all of them.
+func NewEngineAll(input string, maxErrors int) (engine *Engine, err error) {
+	var output *ast.File
+	output, err = parser.parse(input, maxErrors)
 	if err == nil {
-		engine = &Engine{true, "\t", nil, output}
+		engine = &Engine{Autoclose: true, Indentation: "\t", ast: newTree(output), cache: new(cache)}
 	}
 	return
 }
 
-// Render interprets the HAML supplied to the NewEngine method.
+// Parse returns the syntax tree of the given input for use by tools. If the input contains
+// syntax errors, err is an ErrorList holding all of them.
+func Parse(input string) (file *ast.File, err error) {
+	return parser.parse(input, 0)
+}
+
+// Render interprets the HAML supplied to the NewEngine method. If a layout that the template
+// extends cannot be loaded, the output is empty; use Execute to find out why.
 func (self *Engine) Render(scope map[string]interface{}) (output string) {
-	output = self.ast.resolve(scope, self.Indentation, self.Autoclose)
+	var buf bytes.Buffer
+	self.Execute(&buf, scope)
+	output = buf.String()
+	return
+}
+
+// Execute writes the markup for the given scope to w. If the template extends a layout, the
+// layout is loaded with the Loader and rendered around the template: the blocks of the
+// template replace the blocks of the same name in the layout, and the rest of the template is
+// written where the layout says "= yield". Layouts may extend layouts in turn. Nothing is
+// written if a layout cannot be loaded.
+//
+// Partials named by "= render" and "= render_each" are loaded with the Loader as well and see
+// only the locals passed to them.
+//
+// Markup captured with "- content_for" is written by "= content" lines that are rendered after
+// it, like those of a layout, which is rendered after the templates that extend it. Partials
+// capture into the same slots.
+//
+// RenderContext renders values other than maps as well.
+func (self *Engine) Execute(w io.Writer, scope map[string]interface{}) (err error) {
+	return self.RenderContext(context.Background(), w, scope)
+}
+
+// RenderContext writes the markup for data to w like Execute does, but stops, writing nothing and
+// returning an error that wraps ctx.Err(), once ctx is canceled or its deadline passes.
+// Cancellation is noticed between lines of the template and between iterations of loops. Helpers
+// whose first parameter is a context.Context are given ctx before the value they are passed.
+//
+// Data may be a map[string]interface{}, which is used as the scope like Execute does, or any other
+// value, like a struct, a pointer to a struct or a map with string keys. The variables of the
+// template are then looked up as the fields, the methods without parameters or the entries of
+// that value, unless the template declares them; the value itself is not changed.
+func (self *Engine) RenderContext(ctx context.Context, w io.Writer, data interface{}) (err error) {
+	scope, ok := data.(map[string]interface{})
+	if scope == nil {
+		scope = make(map[string]interface{})
+	}
+	var buf bytes.Buffer
+	st := &state{
+		ctx:        ctx,
+		scope:      scope,
+		buf:        &buf,
+		slots:      make(map[string]string),
+		indent:     self.Indentation,
+		autoclose:  self.Autoclose,
+		keyLess:    self.KeyLess,
+		formatters: self.Formatters,
+		helpers:    self.Helpers,
+		globals:    self.Globals,
+
+		maxIterations: self.MaxIterations,
+		maxDepth:      self.MaxDepth,
+		maxOutput:     self.MaxOutput,
+	}
+	if !ok && data != nil {
+		st.root = reflect.ValueOf(data)
+	}
+	if self.Timeout > 0 {
+		var cancel context.CancelFunc
+		st.ctx, cancel = context.WithTimeout(ctx, self.Timeout)
+		defer cancel()
+	}
+	// The variables that the template declares at its top level are gone once it is rendered,
+	// leaving the scope as it was.
+	self.execute(st)
+	if st.unwind(0); !st.check() {
+		return st.err
+	}
+	_, err = w.Write(buf.Bytes())
 	return
 }
+
+// execute renders the template into st.buf inside the layouts it extends, with the settings of
+// st and the Loader of each template for the layouts and partials it names.
+func (self *Engine) execute(st *state) {
+	blocks, yield, loader := st.blocks, st.yield, st.loader
+	st.blocks = make(map[string]string)
+	var layouts []string
+	for engine := self; engine != nil; {
+		st.loader = engine.Loader
+		c := engine.program(st.indent, st.autoclose)
+		if engine.ast.extends == "" {
+			st.buf.Grow(c.size)
+			c.program.exec(st)
+			break
+		}
+		content := c.program.capture(st)
+		st.yield = &content
+		name := templateName(engine.ast.extends)
+		if contains(name, layouts) {
+			st.fail(fmt.Errorf("gohaml: layout %s extends itself", name))
+			break
+		}
+		layouts = append(layouts, name)
+		engine = st.load("layout", name)
+	}
+	st.blocks, st.yield, st.loader = blocks, yield, loader
+}
+
+// templateName returns the name by which a template named in another one is loaded, adding the
+// .haml extension if it has none.
+func templateName(name string) string {
+	if path.Ext(name) == "" {
+		name += ".haml"
+	}
+	return name
+}
+
+// clone returns an Engine with the same template and settings that can be changed without
+// changing this one. It shares the programs compiled for the template.
+func (self *Engine) clone() *Engine {
+	return &Engine{
+		Autoclose:       self.Autoclose,
+		Indentation:     self.Indentation,
+		IncludeCallback: self.IncludeCallback,
+		Loader:          self.Loader,
+		KeyLess:         self.KeyLess,
+		Formatters:      self.Formatters,
+		Helpers:         self.Helpers,
+		Globals:         self.Globals,
+		MaxIterations:   self.MaxIterations,
+		MaxDepth:        self.MaxDepth,
+		MaxOutput:       self.MaxOutput,
+		Timeout:         self.Timeout,
+		ast:             self.ast,
+		cache:           self.cache,
+	}
+}
+
+// program returns the program for the given settings, compiling the tree again if the cached
+// one was compiled for others.
+func (self *Engine) program(indent string, autoclose bool) *compiled {
+	self.cache.Lock()
+	defer self.cache.Unlock()
+	if c := self.cache.compiled; c == nil || c.indent != indent || c.autoclose != autoclose {
+		p := compile(self.ast, indent, autoclose)
+		self.cache.compiled = &compiled{indent, autoclose, p, p.size()}
+	}
+	return self.cache.compiled
+}
@@ -0,0 +1,116 @@
+package gohaml
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/realistschuckle/gohaml/ast"
+)
+
+const astInput = `!!! 5
+%html
+  %body#main.page{:lang => lang}
+    - for i, v := range items
+      %p= v.Name
+    - else
+      %p none
+    - count := 3`
+
+func TestParse(t *testing.T) {
+	file, err := Parse(astInput)
+	if err != nil {
+		t.Fatalf("Input %q\nunexpected error %s", astInput, err)
+	}
+
+	var visited []string
+	ast.Inspect(file, func(n ast.Node) bool {
+		if n != nil {
+			visited = append(visited, fmt.Sprintf("%T@%s", n, n.Pos()))
+		}
+		return true
+	})
+	expected := "*ast.File@1:1 *ast.Doctype@1:1 *ast.Tag@2:1 *ast.Tag@3:3 *ast.Range@4:5 *ast.Tag@5:7 *ast.Script@5:7 *ast.Else@6:5 *ast.Tag@7:7 *ast.Text@7:7 *ast.Assign@8:5"
+	if strings.Join(visited, " ") != expected {
+		t.Errorf("Input %q\nexpected %s\ngot      %s", astInput, expected, strings.Join(visited, " "))
+	}
+
+	body := file.Nodes[1].(*ast.Tag).Children[0].(*ast.Tag)
+	if body.Name != "body" || len(body.Attrs) != 3 {
+		t.Errorf("expected a body tag with 3 attributes but got %#v", body)
+	} else if key, ok := body.Attrs[2].Key.(*ast.Lit); !ok || key.Value != "lang" {
+		t.Errorf("expected a literal attribute key but got %#v", body.Attrs[2].Key)
+	} else if value, ok := body.Attrs[2].Value.(*ast.Path); !ok || value.String() != "lang" {
+		t.Errorf("expected an attribute value path but got %#v", body.Attrs[2].Value)
+	}
+
+	rn := body.Children[0].(*ast.Range)
+	if rn.Key != "i" || rn.Value != "v" || rn.X.(*ast.Path).String() != "items" {
+		t.Errorf("unexpected range %#v", rn)
+	}
+	if rn.Else == nil || len(rn.Else.Body) != 1 {
+		t.Errorf("expected an else with one node but got %#v", rn.Else)
+	}
+	script := rn.Body[0].(*ast.Tag).Inline.(*ast.Script)
+	if names := script.X.(*ast.Path).Names; len(names) != 2 || names[1] != "Name" {
+		t.Errorf("unexpected script path %v", names)
+	}
+	if assign := body.Children[1].(*ast.Assign); assign.Name != "count" || assign.X.(*ast.Lit).Value != 3 || !assign.Define {
+		t.Errorf("unexpected assignment %#v", assign)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	input := "%\n%p\n  - continue\n    text\n  %\n"
+	_, err := Parse(input)
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 3 {
+		t.Fatalf("Input %q\nexpected 3 errors but got %#v", input, err)
+	}
+	expected := "Syntax error on line 3: continue must be nested within a for loop, directly or within a case.\n"
+	if list[1].Error() != expected {
+		t.Errorf("Input %q\nexpected %q\ngot      %q", input, expected, list[1].Error())
+	}
+}
+
+func TestParseLiterals(t *testing.T) {
+	input := "- t := {\"a\": [1, 'b', nil, x.y], 2: {}, true: []}"
+	file, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Input %q\nunexpected error %s", input, err)
+	}
+	m, ok := file.Nodes[0].(*ast.Assign).X.(*ast.MapLit)
+	if !ok || len(m.Keys) != 3 || m.Keys[2].(*ast.Lit).Value != true {
+		t.Fatalf("Input %q\nexpected a map literal with 3 entries but got %#v", input, file.Nodes[0])
+	}
+	if s := m.Values[0].(*ast.SliceLit); s.Elems[1].(*ast.Lit).Value != 'b' || s.Elems[2].(*ast.Lit).Value != nil {
+		t.Errorf("Input %q\nunexpected slice literal %#v", input, s)
+	}
+	if s := fmt.Sprint(m); s != "{\"a\": [1, 'b', nil, x.y], 2: {}, true: []}" {
+		t.Errorf("Input %q\nunexpected text %s", input, s)
+	}
+
+	input = "- x := [\"a\\\"b\\n\"]"
+	file, err = Parse(input)
+	if err != nil {
+		t.Fatalf("Input %q\nunexpected error %s", input, err)
+	}
+	if x := file.Nodes[0].(*ast.Assign).X.(*ast.SliceLit); x.Elems[0].(*ast.Lit).Value != "a\"b\n" || fmt.Sprint(x) != input[len("- x := "):] {
+		t.Errorf("Input %q\nunexpected string literal %#v", input, x)
+	}
+}
+
+func TestParsePipes(t *testing.T) {
+	input := "%p= post.Body | truncate 140 \"…\" | default [] | escape"
+	file, err := Parse(input)
+	if err != nil {
+		t.Fatalf("Input %q\nunexpected error %s", input, err)
+	}
+	p, ok := file.Nodes[0].(*ast.Tag).Inline.(*ast.Script).X.(*ast.Pipe)
+	if !ok || len(p.Stages) != 3 || p.Stages[0].Name != "truncate" || len(p.Stages[0].Args) != 2 || p.Stages[1].Name != "default" {
+		t.Fatalf("Input %q\nexpected a pipe with 3 stages but got %#v", input, file.Nodes[0])
+	}
+	if s := fmt.Sprint(p); s != "post.Body | truncate 140 \"…\" | default [] | escape" {
+		t.Errorf("Input %q\nunexpected text %s", input, s)
+	}
+}
@@ -55,3 +55,38 @@ func BenchmarkRender(b *testing.B) {
 		engine.Render(scope)
 	}
 }
+
+const dynamicTemplate = `%html
+  %head
+    %title= title
+  %body
+    %h1#main.title= title
+    %ul
+      - for i, v := range items
+        %li{:id => v.Name}
+          %span.name= v.Name
+          %span.price= v.Price
+    %p.footer
+      Rendered for
+      = user.Name`
+
+func BenchmarkRenderDynamic(b *testing.B) {
+	b.StopTimer()
+	engine, err := NewEngine(dynamicTemplate)
+	if err != nil {
+		b.Fatal(err)
+	}
+	type item struct {
+		Name  string
+		Price float64
+	}
+	items := make([]item, 20)
+	for i := range items {
+		items[i] = item{fmt.Sprintf("item%d", i), float64(i) * 1.5}
+	}
+	scope := map[string]interface{}{"title": "Items", "items": items, "user": &item{Name: "me"}}
+	b.StartTimer()
+	for i := 0; i != b.N; i++ {
+		engine.Render(scope)
+	}
+}
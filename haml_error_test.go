@@ -0,0 +1,111 @@
+package gohaml
+
+import "testing"
+
+type errorcase struct {
+	input string
+	lines []int
+}
+
+var errorTests = []errorcase{
+	errorcase{"%", []int{1}},
+	errorcase{"%p\n  %\n  %span\n  #", []int{2, 4}},
+	errorcase{"%p{:a => \"b\"\n  %span\n  %\n%p{:a}\n%br", []int{1, 4}},
+	errorcase{"%p\n  - i := \n  %span\n.", []int{2, 4}},
+	errorcase{"%p\n  %span\n\t%span\n%", []int{3, 4}},
+	errorcase{"= content \"head\n- content_for 1\n= content \"ok\"", []int{1, 2}},
+	errorcase{"%p\n  - extends \"a\"\n- extends \"b\"\n- extends \"c\"\n- extends 3", []int{2, 4, 5}},
+	errorcase{"- switch x\n  %p\n- case 1\n- switch y\n  - default\n  - case 2\n    - break\n  - default", []int{2, 3, 7, 8}},
+	errorcase{"- break\n- for i := range n\n  %p\n    - continue\n  - break\n    %p", []int{1, 4, 6}},
+	errorcase{"- a := 1\n%p\n  - a := 2\n- for i := range n\n  - a := 3\n  - i := 4\n  - a = 5\n  - a := 6\n- def m(b)\n  - b := 7", []int{3, 8, 10}},
+	errorcase{"= a |\n%p= b | 1\n= c | d\n- x := y | z(1)", []int{1, 2, 4}},
+	errorcase{"- else\n%p\n  - for i := range n\n    %p\n  - else\n    %p\n  - else\n  %p\n  - else", []int{1, 7, 9}},
+	errorcase{"- x := \"a\\q\"\n- y := \"abc\n- z := 'ab'\n- n := 99999999999999999999\n= x | truncate 99999999999999999999", []int{1, 2, 3, 4, 5}},
+}
+
+func TestErrorList(t *testing.T) {
+	for i, io := range errorTests {
+		engine, err := NewEngineAll(io.input, 0)
+		if engine != nil {
+			t.Errorf("(%d) Input %q\nexpected no engine", i, io.input)
+		}
+		list, ok := err.(ErrorList)
+		if !ok {
+			t.Errorf("(%d) Input %q\nexpected an ErrorList but got %#v", i, io.input, err)
+			continue
+		}
+		if len(list) != len(io.lines) {
+			t.Errorf("(%d) Input %q\nexpected %d errors but got %d: %s", i, io.input, len(io.lines), len(list), list)
+			continue
+		}
+		for j, line := range io.lines {
+			if list[j].Line != line {
+				t.Errorf("(%d) Input %q\nexpected error %d on line %d but got %d", i, io.input, j, line, list[j].Line)
+			}
+		}
+	}
+}
+
+func TestErrorListMaximum(t *testing.T) {
+	input := "%\n%\n%\n%\n%"
+	_, err := NewEngineAll(input, 3)
+	if list, ok := err.(ErrorList); !ok || len(list) != 3 {
+		t.Errorf("Input %q\nexpected 3 errors but got %#v", input, err)
+	}
+	input = "+a\n+b\n+c\n+d"
+	_, err = NewEngineAll(input, 2)
+	if list, ok := err.(ErrorList); !ok || len(list) != 2 || list[1].Line != 2 {
+		t.Errorf("Input %q\nexpected 2 errors but got %#v", input, err)
+	}
+}
+
+func TestFirstErrorOnly(t *testing.T) {
+	input := "%p\n  %\n#"
+	expected := "Syntax error on line 2: Invalid tag: .\n"
+	_, err := NewEngine(input)
+	if e, ok := err.(*Error); !ok {
+		t.Errorf("Input %q\nexpected an *Error but got %#v", input, err)
+	} else if e.Error() != expected || e.Column != 3 {
+		t.Errorf("Input %q\nexpected %q at column 3 but got %q at column %d", input, expected, e.Error(), e.Column)
+	}
+}
+
+var indentationTests = []testcase{
+	testcase{"%one\n  %two\n   %three", "Syntax error on line 3: Inconsistent indentation: 3 spaces used for indentation, but the rest of the document was indented using 2 spaces.\n"},
+	testcase{"%one\n\t%two\n\t\t\t%three", "Syntax error on line 3: The line was indented 2 levels deeper than the previous line.\n"},
+	testcase{"%one\n  %two\n       %three", "Syntax error on line 3: Inconsistent indentation: 7 spaces used for indentation, but the rest of the document was indented using 2 spaces.\n"},
+	testcase{"%br/\n  %two", "Syntax error on line 2: Illegal nesting: nesting within a self-closing tag is illegal.\n"},
+	testcase{"%p tag content\n  %two", "Syntax error on line 2: Illegal nesting: content can't be both given on the same line as %p and nested within it.\n"},
+	testcase{".tagClass= key1\n  %two", "Syntax error on line 2: Illegal nesting: content can't be both given on the same line as %div and nested within it.\n"},
+	testcase{"plain text\n  %two", "Syntax error on line 2: Illegal nesting: nesting within plain text is illegal.\n"},
+	testcase{"= key1\n  %two", "Syntax error on line 2: Illegal nesting: nesting within a script line is illegal.\n"},
+	testcase{"!!! 5\n  %two", "Syntax error on line 2: Illegal nesting: nesting within a header command is illegal.\n"},
+	testcase{"- i := 1\n  %two", "Syntax error on line 2: Illegal nesting: nesting within an assignment is illegal.\n"},
+	testcase{"%p\n  - continue", "Syntax error on line 2: continue must be nested within a for loop, directly or within a case.\n"},
+}
+
+func TestIndentation(t *testing.T) {
+	for i, io := range indentationTests {
+		_, err := NewEngine(io.input)
+		if err == nil {
+			t.Errorf("(%d) Input %q\nexpected %q but got no error", i, io.input, io.expected)
+		} else if err.Error() != io.expected {
+			t.Errorf("(%d) Input %q\nexpected %q\ngot      %q", i, io.input, io.expected, err.Error())
+		}
+	}
+}
+
+func TestIndentationErrorList(t *testing.T) {
+	input := "%one\n  %two\n      %three\n        %four\n  %five\n     %six\n%seven/\n  %eight"
+	_, err := NewEngineAll(input, 0)
+	list, ok := err.(ErrorList)
+	if !ok || len(list) != 3 {
+		t.Errorf("Input %q\nexpected 3 errors but got %#v", input, err)
+		return
+	}
+	for i, pos := range [][2]int{{3, 7}, {6, 6}, {8, 3}} {
+		if list[i].Line != pos[0] || list[i].Column != pos[1] {
+			t.Errorf("Input %q\nexpected error %d at %d:%d but got %d:%d", input, i, pos[0], pos[1], list[i].Line, list[i].Column)
+		}
+	}
+}
@@ -0,0 +1,321 @@
+package gohaml
+
+import (
+	"bytes"
+	goast "go/ast"
+	goparser "go/parser"
+	"go/token"
+	"go/types"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const genTypes = `package main
+
+type genItem struct {
+	Name  string
+	Price float32
+	Count uint8
+}
+
+type genState int
+
+func (self genState) String() string {
+	return [...]string{"off", "on"}[self]
+}
+
+type genPage struct {
+	Title   string
+	Count   int
+	Ratio   float64
+	Checked string
+	Items   []genItem
+	Tags    map[string]string
+	Owner   *genItem
+	Extra   map[string]interface{}
+	Refs    []*genItem
+	Queue   chan string
+	State   genState
+	Last    *genState
+	Raw     []byte
+	Z       complex64
+}
+
+func (self *genPage) Summary() string {
+	return self.Title + "!"
+}
+`
+
+const genMain = `package main
+
+import "os"
+
+func main() {
+	page := &genPage{
+		Title:   "Hello & welcome",
+		Count:   42,
+		Ratio:   0.25,
+		Checked: "true",
+		Items:   []genItem{{"first", 0.1, 3}, {"second", 2, 0}},
+		Tags:    map[string]string{"author": "me", "generator": "hamlgen", "description": "test"},
+		Owner:   &genItem{Name: "owner"},
+		Extra:   map[string]interface{}{"key": "I got map!", "n": 7},
+		Refs:    []*genItem{{Name: "ref"}, {Name: "other"}},
+		Queue:   make(chan string, 2),
+		State:   1,
+		Raw:     []byte("raw"),
+		Z:       complex(1, -2.5),
+	}
+	page.Queue <- "a"
+	page.Queue <- "b"
+	close(page.Queue)
+	if err := renderPage(os.Stdout, page); err != nil {
+		panic(err)
+	}
+}
+`
+
+const genInput = `!!! 5
+%html
+  %head
+    %title= Title
+    - for k, v := range Tags
+      %meta{:name => k, :content => v}
+  %body{:class => Checked}
+    %h1#main.title= Title
+    %p
+      Count:
+      = Count
+    %span= Ratio
+    %ul
+      - for i, v := range Items
+        %li{:id => v.Name}<
+          = i
+        %li= v.Price
+        %li= v.Count
+        %li= loop.Index1
+    %ol
+      - for i, v := range Items
+        - for j, w := range Items
+          %li{:class => loop.Odd}= loop.Parent.Index
+          %li= loop.Last
+    %dl
+      - for k, v := range Tags
+        %dt{:title => loop.Last}= k
+        %dd= v
+    - for v := range Items
+      %b= v
+    - for _, v := range Refs
+      %b= v.Name
+    - for i, v := range Items
+      %s= v.Name
+      - break
+    - for i := range 4
+      %tt= i
+      - continue
+      %tt never
+    - for i, v := range Items
+      - switch v.Count
+        - case 0
+          - continue
+        - case 3, 4
+          %s three
+        - default
+          %s= v.Count
+    - switch Title
+      - case "nope", 3
+        %s nope
+      - default
+        %s= Title
+    - for i := range 0
+      %i= i
+    - else
+      %i none
+      %i at all
+    - for i := range 3
+      %i{:title => loop.Last}= i
+    - for i, r := range "héllo"
+      %u{:title => i}= r
+    - for _ := range Title
+      %s= loop.Length
+    - for s := range Queue
+      %q{:title => loop.Last}= s
+    %p= Owner.Name
+    %p= State
+    %p= Last
+    %p= Raw
+    %p= Z
+    %p= Summary
+    %p= Extra.key
+    %p= Extra.n
+    - label := "static"
+    - n := 12
+    - copy := Owner.Name
+    - on := true
+    - none := nil
+    - flags := {"b": on, "a": false}
+    - for k, v := range flags
+      %i{:title => k}= v
+    - for _, v := range ["x", Count, 'c', none, 2.5]
+      %i= v
+    - pair := [1.5, Title]
+    %i= pair
+    - n = 13
+    - for i := range 2
+      - n := "inner"
+      - copy = Title
+      %em= n
+    - switch Count
+      - case 42
+        - label := 42
+        %em= label
+    %em= label
+    %em= n
+    %em= copy
+    - for _, v := range Items
+      %kbd= v.Name | upcase | truncate 3
+      - continue
+    %kbd= Title | escape
+    %kbd= Extra.missing | default "none"
+    %kbd{:title => Count}= [Title, Count] | join ", "
+    - shout := Owner.Name | upcase
+    %kbd= shout
+    %input{:type => "checkbox", :checked => Checked}`
+
+func genScope() map[string]interface{} {
+	type genItem struct {
+		Name  string
+		Price float32
+		Count uint8
+	}
+	queue := make(chan string, 2)
+	queue <- "a"
+	queue <- "b"
+	close(queue)
+	return map[string]interface{}{
+		"Title":   "Hello & welcome",
+		"Count":   42,
+		"Ratio":   0.25,
+		"Checked": "true",
+		"Items":   []genItem{{"first", 0.1, 3}, {"second", 2, 0}},
+		"Tags":    map[string]string{"author": "me", "generator": "hamlgen", "description": "test"},
+		"Owner":   &genItem{Name: "owner"},
+		"Extra":   map[string]interface{}{"key": "I got map!", "n": 7},
+		"Refs":    []*genItem{{Name: "ref"}, {Name: "other"}},
+		"Queue":   queue,
+		"State":   genState(1),
+		"Last":    (*genState)(nil),
+		"Raw":     []byte("raw"),
+		"Z":       complex64(complex(1, -2.5)),
+		"Summary": "Hello & welcome!",
+	}
+}
+
+type genState int
+
+func (self genState) String() string {
+	return [...]string{"off", "on"}[self]
+}
+
+func TestGenerateGo(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program with the go tool")
+	}
+	gotool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("go tool not found")
+	}
+
+	engine, err := NewEngine(genInput)
+	if err != nil {
+		t.Fatalf("Input %q\nunexpected error %s", genInput, err)
+	}
+	expected := engine.Render(genScope())
+
+	fset := token.NewFileSet()
+	f, err := goparser.ParseFile(fset, "types.go", genTypes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("main", fset, []*goast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src bytes.Buffer
+	if err = engine.GenerateGo(&src, pkg, "renderPage", pkg.Scope().Lookup("genPage").Type()); err != nil {
+		t.Fatalf("Input %q\nunexpected error %s", genInput, err)
+	}
+
+	// Build in a GOPATH of its own in which this package is linked in, so that the generated
+	// code can import it however the package itself was checked out.
+	gopath, err := ioutil.TempDir("", "gohaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(gopath)
+	wd, _ := os.Getwd()
+	link := filepath.Join(gopath, "src", filepath.FromSlash(gohamlPath))
+	os.MkdirAll(filepath.Dir(link), 0755)
+	if err = os.Symlink(wd, link); err != nil {
+		t.Skip("cannot link the package into a GOPATH:", err)
+	}
+	dir := filepath.Join(gopath, "src", "gentest")
+	os.MkdirAll(dir, 0755)
+	files := map[string][]byte{"types.go": []byte(genTypes), "main.go": []byte(genMain), "page_haml.go": src.Bytes()}
+	for name, content := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cmd := exec.Command(gotool, "run", ".")
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GOPATH="+gopath, "GO111MODULE=off", "GOFLAGS=")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("running the generated code failed: %s\n%s\n%s", err, stderr.String(), src.String())
+	}
+	if string(output) != expected {
+		t.Errorf("Generated code\n%s\nexpected output\n%s\nbut got\n%s", src.String(), expected, output)
+	}
+}
+
+var genErrorTests = []testcase{
+	testcase{"= Missing", "gohaml: Missing: main.genPage has no field Missing"},
+	testcase{"= Title.Name", "gohaml: Title.Name: cannot look Name up in string"},
+	testcase{"- for i, v := range Ratio\n  = v", "gohaml: cannot range over Ratio of type float64"},
+	testcase{"- for i, v := range Count\n  = v", "gohaml: range over Count permits only one iteration variable"},
+	testcase{"- for k, v := range {1: \"one\"}\n  = k", "gohaml: cannot generate code to range over {1: \"one\"} in the order of its keys of type interface{}"},
+	testcase{"- n := 1\n- n = \"one\"", "gohaml: cannot assign a value of type string to n of type int"},
+	testcase{"= Title | shout", "gohaml: cannot generate code for helper shout, which is not built in"},
+	testcase{"- for v := range Items | default Refs\n  = v", "gohaml: cannot range over Items | default Refs, whose type is not known until it is rendered"},
+}
+
+func TestGenerateGoErrors(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := goparser.ParseFile(fset, "types.go", genTypes, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkg, err := new(types.Config).Check("main", fset, []*goast.File{f}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range genErrorTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		err = engine.GenerateGo(ioutil.Discard, pkg, "renderPage", pkg.Scope().Lookup("genPage").Type())
+		if err == nil || !strings.HasPrefix(err.Error(), tc.expected) {
+			t.Errorf("Input %q\nexpected error %q\nbut got %v", tc.input, tc.expected, err)
+		}
+	}
+}
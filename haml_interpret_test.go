@@ -1,9 +1,14 @@
 package gohaml
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type assignment struct {
@@ -148,27 +153,28 @@ func TestAssignments(t *testing.T) {
 		scope["akey"] = &subkey{"subkeyvalue"}
 
 		for _, input := range generateAssignments(assignment) {
+			input += "\n= " + assignment.name
 			engine, _ := NewEngine(input)
 			output := engine.Render(scope)
 
-			if _, ok := scope[assignment.name]; !ok {
+			if _, ok := scope[assignment.name]; ok {
 				s := fmt.Sprint(scope)
 				t.Errorf("Input %q\nMap   %s", input, s)
 				return
 			}
 
-			if scope[assignment.name] != assignment.value {
-				s := fmt.Sprint(scope)
-				t.Errorf("Input %q\nMap   %s", input, s)
-				return
-			}
-
-			if len(output) > 0 {
-				t.Errorf("Expected no output, but got %q", output)
+			if expected := fmt.Sprint(assignment.value); output != expected {
+				t.Errorf("Input %q\nexpected %q, but got %q", input, expected, output)
 				return
 			}
 		}
 	}
+
+	scope := map[string]interface{}{"x": "b"}
+	engine, _ := NewEngine("- x := \"a\"\n= x")
+	if output := engine.Render(scope); output != "a" || scope["x"] != "b" {
+		t.Errorf("Expected \"a\" leaving x alone, but got %q with %v", output, scope["x"])
+	}
 }
 
 func generateAssignments(assignment assignment) (assignments []string) {
@@ -183,3 +189,469 @@ func generateAssignments(assignment assignment) (assignments []string) {
 		fmt.Sprintf("  - %s := %s  ", assignment.name, assignment.rhs),
 	}
 }
+
+type embeddedLookup struct {
+	simpleLookup
+	Own string
+}
+
+func TestFieldLookupCache(t *testing.T) {
+	input := "%p= v.SubKey1\n%p= v.Own"
+	expected := "<p>promoted</p>\n<p>own</p>"
+	engine, _ := NewEngine(input)
+
+	done := make(chan string)
+	for i := 0; i < 4; i++ {
+		go func() {
+			scope := map[string]interface{}{"v": &embeddedLookup{simpleLookup{SubKey1: "promoted"}, "own"}}
+			done <- engine.Render(scope)
+		}()
+	}
+	for i := 0; i < 4; i++ {
+		if output := <-done; output != expected {
+			t.Errorf("Expected\n%s\nbut got\n%s\n", expected, output)
+		}
+	}
+}
+
+func TestLoopMetadata(t *testing.T) {
+	scope := map[string]interface{}{
+		"rows": []string{"a", "b", "c"},
+		"cols": map[string]int{"x": 1},
+	}
+	input := "- for i, v := range rows\n  %tr{:class => loop.Odd, :title => loop.Last}\n    - for k, c := range cols\n      %td{:title => loop.Length}= loop.Parent.Index1\n= loop"
+
+	expected := "<tr class=\"class\">\n" +
+		"\t<td title=\"1\">1</td>\n" +
+		"</tr>\n" +
+		"<tr>\n" +
+		"\t<td title=\"1\">2</td>\n" +
+		"</tr>\n" +
+		"<tr class=\"class\" title=\"title\">\n" +
+		"\t<td title=\"1\">3</td>\n" +
+		"</tr>\n"
+	engine, _ := NewEngine(input)
+	output := engine.Render(scope)
+
+	if output != expected {
+		t.Errorf("Expected\n%s\nbut got\n%s\n", expected, output)
+	}
+}
+
+type rangeUser struct {
+	Name  string
+	Admin bool
+}
+
+var rangeFormTests = []testcase{
+	testcase{"- for _, u := range users\n  %p= u.Name", "<p>a</p>\n<p>b</p>"},
+	testcase{"- for i := range users\n  %p= i", "<p>0</p>\n<p>1</p>"},
+	testcase{"- for k, u := range byName\n  %p{:class => u.Admin}= k", "<p class=\"class\">root</p>"},
+	testcase{"- for i := range 3\n  %p= i", "<p>0</p>\n<p>1</p>\n<p>2</p>"},
+	testcase{"- for i := range count\n  %p= loop.Length", "<p>2</p>\n<p>2</p>"},
+	testcase{"- for i, r := range \"añb\"\n  %p{:title => i}= r", "<p title=\"0\">a</p>\n<p title=\"1\">ñ</p>\n<p title=\"3\">b</p>"},
+	testcase{"- for s := range queue\n  %p{:title => loop.Last}= s", "<p>x</p>\n<p title=\"title\">y</p>"},
+	testcase{"- for s := range none\n  %p= s", ""},
+	testcase{"- for _ := range flags\n  %p= loop.Index1", "<p>1</p>\n<p>2</p>"},
+}
+
+func TestRangeForms(t *testing.T) {
+	for _, tc := range rangeFormTests {
+		queue := make(chan string, 2)
+		queue <- "x"
+		queue <- "y"
+		close(queue)
+		scope := map[string]interface{}{
+			"users":  []*rangeUser{&rangeUser{"a", false}, &rangeUser{"b", true}},
+			"byName": map[string]rangeUser{"root": rangeUser{"root", true}},
+			"count":  uint8(2),
+			"queue":  queue,
+			"none":   (chan string)(nil),
+			"flags":  []bool{true, false},
+		}
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+
+	engine, _ := NewEngine("- for i, v := range 3\n  %p= v")
+	if err := engine.Execute(new(strings.Builder), map[string]interface{}{}); err == nil || err.Error() != "gohaml: range over 3 permits only one iteration variable" {
+		t.Errorf("expected an error about the iteration variables but got %v", err)
+	}
+}
+
+var mapOrderTests = []testcase{
+	testcase{"- for k, v := range ints\n  %p= k", "<p>2</p>\n<p>10</p>\n<p>100</p>"},
+	testcase{"- for k, v := range strs\n  %p= v", "<p>1</p>\n<p>2</p>\n<p>3</p>"},
+	testcase{"- for k, v := range bools\n  %p= v", "<p>no</p>\n<p>yes</p>"},
+	testcase{"- for k, v := range mixed\n  %p= k", "<p>1</p>\n<p>a</p>\n<p>b</p>"},
+	testcase{"%ul\n  - for k, v := range strs\n    %li= k\n    %li= v\n%p end", "<ul>\n\t<li>a</li>\n\t<li>1</li>\n\t<li>b</li>\n\t<li>2</li>\n\t<li>c</li>\n\t<li>3</li>\n</ul>\n<p>end</p>"},
+}
+
+func TestMapOrder(t *testing.T) {
+	scope := map[string]interface{}{
+		"ints":  map[int]bool{100: true, 2: true, 10: true},
+		"strs":  map[string]int{"b": 2, "c": 3, "a": 1},
+		"bools": map[bool]string{true: "yes", false: "no"},
+		"mixed": map[interface{}]int{"b": 0, 1: 0, "a": 0},
+	}
+	for _, tc := range mapOrderTests {
+		engine, _ := NewEngine(tc.input)
+		for i := 0; i < 5; i++ {
+			if output := engine.Render(scope); output != tc.expected {
+				t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+				break
+			}
+		}
+	}
+
+	engine, _ := NewEngine("- for k, v := range strs\n  %p= k")
+	engine.KeyLess = func(a, b interface{}) bool {
+		return a.(string) > b.(string)
+	}
+	expected := "<p>c</p>\n<p>b</p>\n<p>a</p>"
+	if output := engine.Render(scope); output != expected {
+		t.Errorf("Expected %q with KeyLess but got %q", expected, output)
+	}
+}
+
+var rangeElseTests = []testcase{
+	testcase{"%ul\n  - for i, v := range items\n    %li= v\n  - else\n    %li none\n    %li at all\n%p", "<ul>\n\t<li>none</li>\n\t<li>at all</li>\n</ul>\n<p />"},
+	testcase{"- for i, v := range missing\n  %p= v\n- else\n  %p none", "<p>none</p>"},
+	testcase{"- for i, v := range full\n  %p= v\n- else\n  %p none", "<p>a</p>\n<p>b</p>"},
+	testcase{"- for k, v := range empty\n  %p= v\n- else\n  - for i, v := range full\n    %b= v\n  - else\n    %p none", "<b>a</b>\n<b>b</b>"},
+}
+
+func TestRangeElse(t *testing.T) {
+	scope := map[string]interface{}{
+		"items": []string{},
+		"full":  []string{"a", "b"},
+		"empty": map[string]int(nil),
+	}
+	for _, tc := range rangeElseTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+}
+
+var branchTests = []testcase{
+	testcase{"%ul\n  - for i, v := range full\n    %li= v\n    - break\n%p", "<ul>\n\t<li>a</li>\n</ul>\n<p />"},
+	testcase{"- for i, v := range full\n  %p= v\n  - continue\n  %b never", "<p>a</p>\n<p>b</p>"},
+	testcase{"%p a\n- for i, v := range full\n  - break\n%p b", "<p>a</p>\n<p>b</p>"},
+	testcase{"- for i, v := range full\n  - for j, w := range full\n    %b= w\n    - break\n  %i= v", "<b>a</b>\n<i>a</i>\n<b>a</b>\n<i>b</i>"},
+	testcase{"- for k, v := range byKey\n  %p= k\n  - break", "<p>x</p>"},
+}
+
+func TestBranches(t *testing.T) {
+	scope := map[string]interface{}{
+		"full":  []string{"a", "b"},
+		"byKey": map[string]int{"y": 2, "x": 1},
+	}
+	for _, tc := range branchTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+}
+
+type switchStatus string
+
+var switchTests = []testcase{
+	testcase{"- switch status\n  - case \"pending\", \"active\"\n    %p on\n  - default\n    %p off", "<p>on</p>"},
+	testcase{"- switch small\n  - default\n    %p other\n  - case 2.0\n    %p two\n  - case 3\n    %p three", "<p>three</p>"},
+	testcase{"- switch small\n  - case other\n    %p other", "<p>other</p>"},
+	testcase{"- switch status\n  - case 1\n    %p one", ""},
+	testcase{"- switch missing\n  - case \"x\"\n    %p x\n  - default\n    %p none", "<p>none</p>"},
+	testcase{"%ul\n  - for i, v := range nums\n    - switch v\n      - case 3\n        - break\n      - default\n        %li= v\n%p", "<ul>\n\t<li>1</li>\n\t<li>2</li>\n</ul>\n<p />"},
+	testcase{"- for i, v := range nums\n  - switch v\n    - case 2, 4\n      - continue\n  %p= v", "<p>1</p>\n<p>3</p>"},
+	testcase{"- switch small\n  - case 1\n    %p one\n%p a\n- switch small\n  - case 2\n    %p two\n%p b", "<p>a</p>\n<p>b</p>"},
+	testcase{"%div\n  - for i, v := range nums\n    - switch v\n      - case 1, 3\n        %p= v", "<div>\n\t<p>1</p>\n\t<p>3</p>\n</div>"},
+}
+
+func TestSwitch(t *testing.T) {
+	scope := map[string]interface{}{
+		"status": switchStatus("active"),
+		"small":  uint8(3),
+		"other":  int64(3),
+		"nums":   []int{1, 2, 3, 4},
+	}
+	for _, tc := range switchTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+}
+
+var scopeTests = []testcase{
+	testcase{"- for i := range 2\n  - x := i\n%p= x", "<p>outer</p>"},
+	testcase{"- x := x\n- for i := range 2\n  - x = i\n%p= x", "<p>1</p>"},
+	testcase{"- x := \"mine\"\n- for i := range 1\n  %p= x\n  - x := \"inner\"\n  %p= x\n%p= x", "<p>mine</p>\n<p>inner</p>\n<p>mine</p>"},
+	testcase{"- for i := range 2\n  %p= x\n  - x := i", "<p>outer</p>\n<p>outer</p>"},
+	testcase{"- for i := range 1\n  - i := \"shadow\"\n  %p= i\n  - x := i\n%p= x", "<p>shadow</p>\n<p>outer</p>"},
+	testcase{"- switch x\n  - case \"outer\"\n    - x := \"case\"\n    %p= x\n%p= x", "<p>case</p>\n<p>outer</p>"},
+	testcase{"- for i := range 0\n- else\n  - x := 1\n  %p= x\n%p= x", "<p>1</p>\n<p>outer</p>"},
+	testcase{"- x := x\n%p= x\n- x = \"new\"\n%p= x", "<p>outer</p>\n<p>new</p>"},
+}
+
+func TestScopes(t *testing.T) {
+	for _, tc := range scopeTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		scope := map[string]interface{}{"x": "outer"}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+		if _, ok := scope["i"]; ok {
+			t.Errorf("Input %q\nexpected i to be gone from the scope", tc.input)
+		}
+	}
+
+	for _, input := range []string{"%p\n  - y = 1", "- x = 1", "- y := 1\n- def m()\n  - y = 2", "- for i := range 1\n  - y := i\n- y = 2"} {
+		expected := "Cannot assign to"
+		if _, err := NewEngine(input); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Input %q\nexpected an error about the undeclared assignment but got %v", input, err)
+		}
+	}
+}
+
+var literalTests = []testcase{
+	testcase{"- visible := true\n%p= visible\n- visible = false\n%p= visible", "<p>true</p>\n<p>false</p>"},
+	testcase{"- none := nil\n%p= none", "<p />"},
+	testcase{"- r := 'é'\n%p= r", "<p>233</p>"},
+	testcase{"- for i, v := range [\"a\", 2, x, true]\n  %p= v", "<p>a</p>\n<p>2</p>\n<p>outer</p>\n<p>true</p>"},
+	testcase{"- labels := {\"ok\": \"Fine\", \"err\": \"Broken\"}\n%p= labels.err", "<p>Broken</p>"},
+	testcase{"- t := {\"a\": {\"b\": [1, 2]}}\n%p= t.a.b", "<p>[1 2]</p>"},
+	testcase{"- for k, v := range {2: \"two\", 1: \"one\", 'a': nil}\n  %p= k", "<p>1</p>\n<p>2</p>\n<p>97</p>"},
+	testcase{"- switch x\n  - case nil, 'o'\n    %p rune\n  - case \"outer\"\n    %p string", "<p>string</p>"},
+	testcase{"- for i := range []\n  %p= i\n- else\n  %p empty", "<p>empty</p>"},
+}
+
+func TestLiterals(t *testing.T) {
+	for _, tc := range literalTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		if output := engine.Render(map[string]interface{}{"x": "outer"}); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+}
+
+type celsius float64
+
+type marshaled struct{}
+
+func (marshaled) MarshalText() ([]byte, error) {
+	return []byte("as text"), nil
+}
+
+var formatTests = []testcase{
+	testcase{"%p= f32", "<p>0.1</p>"},
+	testcase{"%p= f64", "<p>0.1</p>"},
+	testcase{"%p= u8\n%p= u64", "<p>200</p>\n<p>18446744073709551615</p>"},
+	testcase{"%p= c", "<p>(1+2i)</p>"},
+	testcase{"%p= raw", "<p>bytes</p>"},
+	testcase{"%p= when", "<p>2024-03-01T12:30:00Z</p>"},
+	testcase{"%p= wait", "<p>1m30s</p>"},
+	testcase{"%p= err", "<p>broken</p>"},
+	testcase{"%p= text", "<p>as text</p>"},
+	testcase{"%p= temp\n%p= ptemp", "<p>21.5 °C</p>\n<p>21.5 °C</p>"},
+	testcase{"%p{:title => f32}= u8", "<p title=\"0.1\">200</p>"},
+}
+
+func TestFormat(t *testing.T) {
+	temp := celsius(21.5)
+	scope := map[string]interface{}{
+		"f32":   float32(0.1),
+		"f64":   0.1,
+		"u8":    uint8(200),
+		"u64":   uint64(1<<64 - 1),
+		"c":     complex(1, 2),
+		"raw":   []byte("bytes"),
+		"when":  time.Date(2024, 3, 1, 12, 30, 0, 0, time.UTC),
+		"wait":  90 * time.Second,
+		"err":   errors.New("broken"),
+		"text":  marshaled{},
+		"temp":  temp,
+		"ptemp": &temp,
+	}
+	for _, tc := range formatTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		engine.Formatters = map[reflect.Type]func(interface{}) string{
+			reflect.TypeOf(temp): func(v interface{}) string { return fmt.Sprintf("%.1f °C", v) },
+		}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+}
+
+type pipecase struct {
+	input    string
+	expected string
+	err      string
+}
+
+var pipeTests = []pipecase{
+	pipecase{"%p= body | truncate 5 | escape", "<p>&lt;b&gt;bo...</p>", ""},
+	pipecase{"= body | upcase", "<B>BOLD</B>", ""},
+	pipecase{"%p= missing | default \"none\"\n%p= empty | default 0", "<p>none</p>\n<p>0</p>", ""},
+	pipecase{"%p= tags | join \", \" | upcase", "<p>GO, HAML</p>", ""},
+	pipecase{"%p= n | wrap \"[\" \"]\"", "<p>[7]</p>", ""},
+	pipecase{"%p= n | add 1.9 | add 2", "<p>10</p>", ""},
+	pipecase{"%p= tags | count\n%p= \"a|b\"", "<p>2</p>\n<p>a|b</p>", ""},
+	pipecase{"- top := tags | default []\n- for _, t := range top\n  %p= t", "<p>go</p>\n<p>haml</p>", ""},
+	pipecase{"- switch n | add 1\n  - case 8\n    %p eight", "<p>eight</p>", ""},
+	pipecase{"%p= body | upcase | fail", "", "gohaml: helper fail: <B>BOLD</B> failed"},
+	pipecase{"%p= body | shout", "", "gohaml: helper shout is not defined"},
+	pipecase{"%p= body | truncate \"five\"", "", "gohaml: cannot use string as argument 2 of helper truncate, which takes int"},
+}
+
+func TestPipes(t *testing.T) {
+	scope := map[string]interface{}{
+		"body":  "<b>bold</b>",
+		"empty": []int{},
+		"tags":  []string{"go", "haml"},
+		"n":     7,
+	}
+	for _, tc := range pipeTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		engine.Helpers = map[string]interface{}{
+			"wrap":  func(s string, ends ...string) string { return strings.Join(ends, s) },
+			"add":   func(a, b int) int { return a + b },
+			"count": func(v []string) int { return len(v) },
+			"fail":  func(s string) (string, error) { return "", errors.New(s + " failed") },
+		}
+		var buf bytes.Buffer
+		err = engine.Execute(&buf, scope)
+		if output := buf.String(); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("Input %q\nexpected error %q but got %v", tc.input, tc.err, err)
+		}
+	}
+}
+
+type rootPage struct {
+	Title string
+	Owner *rootOwner
+	Tags  map[string]string
+}
+
+type rootOwner struct {
+	Name string
+}
+
+func (self *rootPage) Heading() string {
+	return "# " + self.Title
+}
+
+var rootTests = []testcase{
+	testcase{"%h1= Title\n%p= Owner.Name\n%p= Tags.lang", "<h1>Home</h1>\n<p>me</p>\n<p>go</p>"},
+	testcase{"%h1= Heading\n%p= Missing", "<h1># Home</h1>\n<p />"},
+	testcase{"- Title := \"Local\"\n%h1= Title\n- for _, v := range Tags\n  %p= v", "<h1>Local</h1>\n<p>go</p>"},
+	testcase{"- def m()\n  %p= Title\n+m", "<p />"},
+}
+
+func TestRootValue(t *testing.T) {
+	page := &rootPage{Title: "Home", Owner: &rootOwner{"me"}, Tags: map[string]string{"lang": "go"}}
+	for _, tc := range rootTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		var buf bytes.Buffer
+		if err = engine.RenderContext(context.Background(), &buf, page); err != nil || buf.String() != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q (%v)", tc.input, tc.expected, buf.String(), err)
+		}
+	}
+	if page.Title != "Home" {
+		t.Errorf("expected the root value to be left alone but its title is %q", page.Title)
+	}
+
+	engine, _ := NewEngine("- n := 1\n%p= lang\n%p= n")
+	for _, data := range []interface{}{map[string]string{"lang": "go"}, *page, nil, map[string]interface{}(nil)} {
+		var buf bytes.Buffer
+		if err := engine.RenderContext(context.Background(), &buf, data); err != nil || !strings.HasSuffix(buf.String(), "<p>1</p>") {
+			t.Errorf("Data %#v\nunexpected output %q (%v)", data, buf.String(), err)
+		}
+	}
+
+	type rootKey string
+	engine, _ = NewEngine("%p= lang")
+	for _, data := range []interface{}{map[rootKey]string{"lang": "go"}, map[int]string{1: "go"}, []int{1, 2}, "go"} {
+		expected := "<p />"
+		if _, ok := data.(map[rootKey]string); ok {
+			expected = "<p>go</p>"
+		}
+		var buf bytes.Buffer
+		if err := engine.RenderContext(context.Background(), &buf, data); err != nil || buf.String() != expected {
+			t.Errorf("Data %#v\nexpected %q\ngot      %q (%v)", data, expected, buf.String(), err)
+		}
+	}
+}
+
+var globalTests = []testcase{
+	testcase{"%p= site\n%p= version", "<p>gohaml</p>\n<p>1.0</p>"},
+	testcase{"- site := \"local\"\n%p= site\n%p= lang", "<p>local</p>\n<p>go</p>"},
+	testcase{"- def m()\n  %p= site\n+m\n= render \"partial\"", "<p>gohaml</p>\n<span>gohaml</span>"},
+}
+
+func TestGlobals(t *testing.T) {
+	globals := map[string]interface{}{"site": "gohaml", "version": "1.0", "lang": "none"}
+	for _, tc := range globalTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		engine.Globals = globals
+		engine.Loader = mapLoader{"partial.haml": "%span= site"}
+		if output := engine.Render(map[string]interface{}{"lang": "go"}); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+	engine, _ := NewEngine("%p= Title\n%p= site")
+	engine.Globals = globals
+	var buf bytes.Buffer
+	engine.RenderContext(context.Background(), &buf, &rootPage{Title: "Home"})
+	if expected := "<p>Home</p>\n<p>gohaml</p>"; buf.String() != expected {
+		t.Errorf("expected %q\ngot      %q", expected, buf.String())
+	}
+}
@@ -65,8 +65,8 @@ var autoCloseTests = []testcase{
 	testcase{"%input{:type => \"checkbox\", :checked => false}", "<input type=\"checkbox\" />"},
 	testcase{"%input{:type => \"checkbox\", :checked => outputTrue}", "<input type=\"checkbox\" checked=\"checked\" />"},
 	testcase{"%input{:type => \"checkbox\", cd => outputTrue}", "<input type=\"checkbox\" checked=\"checked\" />"},
-	testcase{"%one\n  %two\n   %three\n", "<one>\n\t<two>\n\t\t<three />\n\t</two>\n</one>"},
-	testcase{"%one\n  %two\n   %three\n      ", "<one>\n\t<two>\n\t\t<three />\n\t</two>\n</one>"},
+	testcase{"%one\n  %two\n    %three\n", "<one>\n\t<two>\n\t\t<three />\n\t</two>\n</one>"},
+	testcase{"%one\n  %two\n    %three\n      ", "<one>\n\t<two>\n\t\t<three />\n\t</two>\n</one>"},
 	testcase{"!!!", "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">"},
 	testcase{"!!! Strict", "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\">"},
 	testcase{"!!! Frameset", "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Frameset//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd\">"},
@@ -75,6 +75,7 @@ var autoCloseTests = []testcase{
 	testcase{"!!! Basic", "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML Basic 1.1//EN\" \"http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd\">"},
 	testcase{"!!! Mobile", "<!DOCTYPE html PUBLIC \"-//WAPFORUM//DTD XHTML Mobile 1.2//EN\" \"http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd\">"},
 	testcase{"!!! RDFa", "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML+RDFa 1.0//EN\" \"http://www.w3.org/MarkUp/DTD/xhtml-rdfa-1.dtd\">"},
+	testcase{"%p\n  :-) smile", "<p>\n\t:-) smile\n</p>"},
 }
 
 func TestAutoCloseIO(t *testing.T) {
@@ -129,3 +130,16 @@ func TestNoAutoCloseIO(t *testing.T) {
 		}
 	}
 }
+
+func TestSettingsChangedAfterRender(t *testing.T) {
+	engine, _ := NewEngine("%p\n  %br")
+	expected := []string{"<p>\n\t<br />\n</p>", "<p>\n  <br>\n</p>"}
+	if output := engine.Render(nil); output != expected[0] {
+		t.Errorf("expected %q\ngot      %q", expected[0], output)
+	}
+	engine.Indentation = "  "
+	engine.Autoclose = false
+	if output := engine.Render(nil); output != expected[1] {
+		t.Errorf("expected %q\ngot      %q", expected[1], output)
+	}
+}
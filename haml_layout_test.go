@@ -0,0 +1,96 @@
+package gohaml
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// mapLoader loads templates from a map of names to sources.
+type mapLoader map[string]string
+
+func (self mapLoader) Load(id interface{}) (engine *Engine, err error) {
+	src, ok := self[fmt.Sprint(id)]
+	if !ok {
+		return nil, fmt.Errorf("%v: no such template", id)
+	}
+	if engine, err = NewEngine(src); err == nil {
+		engine.Loader = self
+	}
+	return
+}
+
+var layouts = mapLoader{
+	"app.haml":           "%html\n  %head\n    %title\n      - block title\n        Default\n  %body\n    - block content\n      %p default\n    = yield",
+	"layouts/plain.haml": "%div\n  = yield",
+	"nested.haml":        "- extends \"app\"\n- block title\n  Nested\n%p nested content\n%div\n  = yield",
+	"loop.haml":          "- extends \"loop\"",
+	"head.haml":          "%html\n  %head\n    = content \"head\"\n  %body\n    = yield",
+}
+
+var layoutTests = []testcase{
+	testcase{"- extends \"layouts/plain\"\n%p one\n%p two", "<div>\n\t<p>one</p>\n\t<p>two</p>\n</div>"},
+	testcase{"- extends \"layouts/plain.haml\"\n%p one", "<div>\n\t<p>one</p>\n</div>"},
+	testcase{"- extends \"app\"\n- block content\n  %p mine\n  %p= key1\n%span yielded",
+		"<html>\n\t<head>\n\t\t<title>\n\t\t\tDefault\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<p>mine</p>\n\t\t<p>value1</p>\n\t\t<span>yielded</span>\n\t</body>\n</html>"},
+	testcase{"%span before\n- block title\n  Mine\n- extends \"app\"\n%span after",
+		"<html>\n\t<head>\n\t\t<title>\n\t\t\tMine\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<p>default</p>\n\t\t<span>before</span>\n\t\t<span>after</span>\n\t</body>\n</html>"},
+	testcase{"- extends \"nested\"\n- block title\n  Innermost\n%b inner",
+		"<html>\n\t<head>\n\t\t<title>\n\t\t\tInnermost\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<p>default</p>\n\t\t<p>nested content</p>\n\t\t<div>\n\t\t\t<b>inner</b>\n\t\t</div>\n\t</body>\n</html>"},
+	testcase{"%p\n  - block title\n    Standalone", "<p>\n\tStandalone\n</p>"},
+	testcase{"- extends \"head\"\n%div\n  %p body\n  - content_for \"head\"\n    %script{:src => \"a.js\"}\n  - content_for \"head\"\n    %meta{:name => key1}\n%p end",
+		"<html>\n\t<head>\n\t\t<script src=\"a.js\" />\n\t\t<meta name=\"value1\" />\n\t</head>\n\t<body>\n\t\t<div>\n\t\t\t<p>body</p>\n\t\t</div>\n\t\t<p>end</p>\n\t</body>\n</html>"},
+	testcase{"- content_for \"x\"\n  %b bold\n  %i italic\n%p\n  = content \"x\"\n= content \"missing\"", "<p>\n\t<b>bold</b>\n\t<i>italic</i>\n</p>\n"},
+	testcase{"- content := \"body\"\n%p= content | upcase\n%p= content | truncate 2", "<p>BODY</p>\n<p>bo...</p>"},
+}
+
+func TestLayouts(t *testing.T) {
+	for _, tc := range layoutTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		engine.Loader = layouts
+		var buf bytes.Buffer
+		if err = engine.Execute(&buf, map[string]interface{}{"key1": "value1"}); err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+		} else if buf.String() != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, buf.String())
+		}
+	}
+}
+
+var layoutErrorTests = []testcase{
+	testcase{"- extends \"missing\"", "gohaml: layout missing.haml: missing.haml: no such template"},
+	testcase{"- extends \"loop\"", "gohaml: layout loop.haml extends itself"},
+}
+
+func TestLayoutErrors(t *testing.T) {
+	for _, tc := range layoutErrorTests {
+		engine, _ := NewEngine(tc.input)
+		engine.Loader = layouts
+		var buf bytes.Buffer
+		if err := engine.Execute(&buf, nil); err == nil || err.Error() != tc.expected {
+			t.Errorf("Input %q\nexpected error %q\ngot %v", tc.input, tc.expected, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Input %q\nexpected no output but got %q", tc.input, buf.String())
+		}
+	}
+
+	engine, _ := NewEngine("- extends \"app\"")
+	if err := engine.Execute(new(bytes.Buffer), nil); err == nil || !strings.Contains(err.Error(), "without a Loader") {
+		t.Errorf("expected an error about the missing Loader but got %v", err)
+	}
+}
+
+func TestYieldVariable(t *testing.T) {
+	input := "= yield\n- def m()\n  %div\n    = yield\n+m\n  %b block"
+	engine, _ := NewEngine(input)
+	expected := "value\n<div>\n\t<b>block</b>\n</div>"
+	if output := engine.Render(map[string]interface{}{"yield": "value"}); output != expected {
+		t.Errorf("Input %q\nexpected %q\ngot      %q", input, expected, output)
+	}
+}
@@ -0,0 +1,117 @@
+package gohaml
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type limitcase struct {
+	input string
+	limit func(engine *Engine)
+	err   string
+}
+
+var limitTests = []limitcase{
+	limitcase{"- for i := range 3\n  - for j := range 3\n    %p= j",
+		func(e *Engine) { e.MaxIterations = 5 }, "gohaml: render exceeds the maximum of 5 loop iterations"},
+	limitcase{"- for i := range 3\n  - for j := range 3\n    %p= j",
+		func(e *Engine) { e.MaxIterations = 12 }, ""},
+	limitcase{"= render_each \"row\", users",
+		func(e *Engine) { e.MaxIterations = 1 }, "gohaml: render exceeds the maximum of 1 loop iterations"},
+	limitcase{"- def down(n)\n  %p\n    +down(n)\n+down(1)",
+		func(e *Engine) { e.MaxDepth = 3 }, "gohaml: mixin down is nested more than 3 deep"},
+	limitcase{"= render \"self\"",
+		func(e *Engine) { e.MaxDepth = 4 }, "gohaml: partial self.haml is nested more than 4 deep"},
+	limitcase{"= render \"row\", row: users",
+		func(e *Engine) { e.MaxDepth = 1 }, ""},
+	limitcase{"- def f()\n  +f\n+f",
+		func(e *Engine) {}, "gohaml: mixin f is nested more than 1000 deep"},
+	limitcase{"= render \"self\"",
+		func(e *Engine) {}, "gohaml: partial self.haml is nested more than 1000 deep"},
+	limitcase{"- for i := range 100\n  %p= i",
+		func(e *Engine) { e.MaxOutput = 20 }, "gohaml: render exceeds the maximum output of 20 bytes"},
+	limitcase{"- def m\n  - for i := range 100\n    %p= i\n- content_for \"x\"\n  +m",
+		func(e *Engine) { e.MaxOutput = 50 }, "gohaml: render exceeds the maximum output of 50 bytes"},
+	limitcase{"- for i := range 3\n  %p= i",
+		func(e *Engine) { e.MaxOutput = 26 }, ""},
+	limitcase{"%p= long",
+		func(e *Engine) { e.MaxOutput = 5 }, "gohaml: render exceeds the maximum output of 5 bytes"},
+}
+
+func TestLimits(t *testing.T) {
+	loader := mapLoader{"self.haml": "%p\n  = render \"self\"", "row.haml": partials["row.haml"]}
+	scope := map[string]interface{}{"users": []struct{ Name string }{{"a"}, {"b"}}, "long": "sixteen bytes..."}
+	for _, tc := range limitTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		engine.Loader = loader
+		tc.limit(engine)
+		var buf bytes.Buffer
+		err = engine.Execute(&buf, scope)
+		if (err == nil && tc.err != "") || (err != nil && err.Error() != tc.err) {
+			t.Errorf("Input %q\nexpected error %q but got %v", tc.input, tc.err, err)
+		}
+		if err != nil && buf.Len() > 0 {
+			t.Errorf("Input %q\nexpected no output but got %q", tc.input, buf.String())
+		}
+	}
+}
+
+func TestOutputLimitBeforeWriting(t *testing.T) {
+	st := &state{ctx: context.Background(), buf: new(bytes.Buffer), maxOutput: 10}
+	st.write("0123")
+	st.write("456789abcdef")
+	if st.err == nil || st.buf.String() != "0123" {
+		t.Errorf("expected the value beyond the limit to be left out but got %q (%v)", st.buf.String(), st.err)
+	}
+}
+
+func TestTimeout(t *testing.T) {
+	engine, _ := NewEngine("- for v := range queue\n  %p= v")
+	engine.Timeout = 20 * time.Millisecond
+	queue := make(chan int, 1)
+	queue <- 1
+	err := engine.Execute(new(bytes.Buffer), map[string]interface{}{"queue": queue})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected the render to exceed its deadline but got %v", err)
+	}
+}
+
+type contextKey string
+
+func TestRenderContext(t *testing.T) {
+	engine, _ := NewEngine("- for i := range 5\n  %p= i | stop\n%p= user | greet")
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), contextKey("site"), "gohaml"))
+	defer cancel()
+	var seen int
+	engine.Helpers = map[string]interface{}{
+		"stop": func(i int) int {
+			if seen = i; i == 2 {
+				cancel()
+			}
+			return i
+		},
+		"greet": func(ctx context.Context, name string) string {
+			return name + "@" + ctx.Value(contextKey("site")).(string)
+		},
+	}
+	var buf bytes.Buffer
+	err := engine.RenderContext(ctx, &buf, map[string]interface{}{"user": "me"})
+	if !errors.Is(err, context.Canceled) || seen != 2 || buf.Len() > 0 {
+		t.Errorf("expected the render to stop after the third iteration but got %v after %d with %q", err, seen, buf.String())
+	}
+
+	engine.Helpers["stop"] = func(i int) int { return i }
+	buf.Reset()
+	ctx = context.WithValue(context.Background(), contextKey("site"), "gohaml")
+	expected := "<p>0</p>\n<p>1</p>\n<p>2</p>\n<p>3</p>\n<p>4</p>\n<p>me@gohaml</p>"
+	if err = engine.RenderContext(ctx, &buf, map[string]interface{}{"user": "me"}); err != nil || buf.String() != expected {
+		t.Errorf("expected %q\ngot      %q (%v)", expected, buf.String(), err)
+	}
+}
@@ -0,0 +1,54 @@
+package gohaml
+
+import "testing"
+
+type mixinNode struct {
+	Name string
+}
+
+var mixinTests = []testcase{
+	testcase{"- def field(name, label)\n  %label{:for => name}= label\n  %input{:name => name}\n%form\n  +field(\"email\", \"E-mail\")\n  +field(\"pw\", key1)",
+		"<form>\n\t<label for=\"email\">E-mail</label>\n\t<input name=\"email\" />\n\t<label for=\"pw\">value1</label>\n\t<input name=\"pw\" />\n</form>"},
+	testcase{"- def card(title)\n  .card\n    %h2= title\n    = yield\n%div\n  +card(user.Name)\n    %p body\n    %p= key1",
+		"<div>\n\t<div class=\"card\">\n\t\t<h2>root</h2>\n\t\t<p>body</p>\n\t\t<p>value1</p>\n\t</div>\n</div>"},
+	testcase{"- def set()\n  - key1 := \"changed\"\n  %p= key1\n+set\n%p= key1", "<p>changed</p>\n<p>value1</p>"},
+	testcase{"+hr\n- def hr\n  %hr", "<hr />"},
+	testcase{"- def item(node)\n  %li= node.Name\n- def list(nodes)\n  %ul\n    - for i, node := range nodes\n      +item(node)\n+list(children)",
+		"<ul>\n\t<li>a</li>\n\t<li>b</li>\n</ul>"},
+	testcase{"- def badge(n)\n  - switch n\n    - case 2\n      %b two\n    - default\n      %b other\n+badge(2)", "<b>two</b>"},
+	testcase{"- def each(x)\n  - for i, v := range x\n    %i= v\n+each(true)", ""},
+	testcase{"%p\n  +1 for this\n+ plus\n\\+more info", "<p>\n\t+1 for this\n</p>\n+ plus\n+more info"},
+	testcase{"- def each(x)\n  - for i, v := range x\n    %i= v\n+each([1, 2])", "<i>1</i>\n<i>2</i>"},
+}
+
+func TestMixins(t *testing.T) {
+	for _, tc := range mixinTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		scope := map[string]interface{}{
+			"key1":     "value1",
+			"user":     mixinNode{"root"},
+			"children": []mixinNode{mixinNode{"a"}, mixinNode{"b"}},
+		}
+		if output := engine.Render(scope); output != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, output)
+		}
+	}
+}
+
+var mixinErrorTests = []testcase{
+	testcase{"+missing", "Syntax error on line 1: Mixin \"missing\" is not defined.\n"},
+	testcase{"- def a(x, y)\n  %p\n%div\n  +a(1)", "Syntax error on line 4: Mixin \"a\" takes 2 arguments, but 1 were given.\n"},
+	testcase{"%div\n  - def a()\n    %p", "Syntax error on line 2: Illegal nesting: def must be at the top level of the template.\n"},
+}
+
+func TestMixinErrors(t *testing.T) {
+	for _, tc := range mixinErrorTests {
+		if _, err := NewEngine(tc.input); err == nil || err.Error() != tc.expected {
+			t.Errorf("Input %q\nexpected error %q\ngot %v", tc.input, tc.expected, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+package gohaml
+
+import (
+	"bytes"
+	"testing"
+)
+
+var partials = mapLoader{
+	"shared/user_card.haml": ".card\n  %h2= user.Name\n  %p= greeting",
+	"row.haml":              "%tr{:id => row_index}\n  %td= row.Name",
+	"cell.haml":             "%td{:class => loop.Last}= loop.Parent.Index1",
+	"script.haml":           "- content_for \"head\"\n  %script{:src => src}\n%span= src",
+	"page.haml":             "- extends \"head\"\n= render \"script\", src: \"a.js\"",
+	"head.haml":             layouts["head.haml"],
+	"count.haml":            "- switch n\n  - case 1\n    %p one\n  - default\n    %p many",
+}
+
+var partialTests = []testcase{
+	testcase{"%div\n  = render \"shared/user_card\", user: user, greeting: \"hi\"",
+		"<div>\n\t<div class=\"card\">\n\t\t<h2>me</h2>\n\t\t<p>hi</p>\n\t</div>\n</div>"},
+	testcase{"%table\n  = render_each \"row\", users",
+		"<table>\n\t<tr id=\"0\">\n\t\t<td>a</td>\n\t</tr>\n\t<tr id=\"1\">\n\t\t<td>b</td>\n\t</tr>\n</table>"},
+	testcase{"- for i, u := range users\n  = render \"shared/user_card\", user: u, greeting: i",
+		"<div class=\"card\">\n\t<h2>a</h2>\n\t<p>0</p>\n</div>\n<div class=\"card\">\n\t<h2>b</h2>\n\t<p>1</p>\n</div>"},
+	testcase{"- for i, u := range users\n  %tr\n    = render_each \"cell\", users",
+		"<tr>\n\t<td>1</td>\n\t<td class=\"class\">1</td>\n</tr>\n<tr>\n\t<td>2</td>\n\t<td class=\"class\">2</td>\n</tr>"},
+	testcase{"= render \"page\"",
+		"<html>\n\t<head>\n\t\t<script src=\"a.js\" />\n\t</head>\n\t<body>\n\t\t<span>a.js</span>\n\t</body>\n</html>"},
+	testcase{"= render \"count\", n: 1", "<p>one</p>"},
+	testcase{"- render := key1\n= render\n= render | upcase\n- render_each := [key1]\n%p= render_each | join \", \"", "value1\nVALUE1\n<p>value1</p>"},
+}
+
+func TestPartials(t *testing.T) {
+	type user struct {
+		Name string
+	}
+	for _, tc := range partialTests {
+		engine, err := NewEngine(tc.input)
+		if err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+			continue
+		}
+		engine.Loader = partials
+		scope := map[string]interface{}{
+			"key1":  "value1",
+			"user":  &user{"me"},
+			"users": []user{user{"a"}, user{"b"}},
+		}
+		var buf bytes.Buffer
+		if err = engine.Execute(&buf, scope); err != nil {
+			t.Errorf("Input %q\nunexpected error %s", tc.input, err)
+		} else if buf.String() != tc.expected {
+			t.Errorf("Input %q\nexpected %q\ngot      %q", tc.input, tc.expected, buf.String())
+		}
+	}
+}
+
+var partialErrorTests = []testcase{
+	testcase{"%p\n  = render \"missing\"", "gohaml: partial missing.haml: missing.haml: no such template"},
+	testcase{"= render_each \"row\", key1", "gohaml: cannot render row.haml for each element of key1"},
+}
+
+func TestPartialErrors(t *testing.T) {
+	for _, tc := range partialErrorTests {
+		engine, _ := NewEngine(tc.input)
+		engine.Loader = partials
+		var buf bytes.Buffer
+		if err := engine.Execute(&buf, map[string]interface{}{"key1": "value1"}); err == nil || err.Error() != tc.expected {
+			t.Errorf("Input %q\nexpected error %q\ngot %v", tc.input, tc.expected, err)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("Input %q\nexpected no output but got %q", tc.input, buf.String())
+		}
+	}
+}
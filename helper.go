@@ -0,0 +1,166 @@
+package gohaml
+
+import (
+	"context"
+	"fmt"
+	"html"
+	"reflect"
+	"strings"
+	"unicode/utf8"
+)
+
+// helpers holds the helpers that the stages of a pipe can name, keyed by name, unless the Helpers
+// of the Engine define one of the same name.
+var helpers = map[string]interface{}{
+	"escape":   html.EscapeString,
+	"upcase":   strings.ToUpper,
+	"downcase": strings.ToLower,
+	"strip":    strings.TrimSpace,
+	"truncate": truncateHelper,
+	"default":  defaultHelper,
+	"join":     joinHelper,
+}
+
+// truncateHelper cuts s to its first n runes followed by "..." if it is longer than that.
+func truncateHelper(s string, n int) string {
+	if n < 0 || utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "..."
+}
+
+// defaultHelper returns d if v is missing, the zero value of its type, like an empty string, or an
+// empty slice or map, and v otherwise.
+func defaultHelper(v interface{}, d interface{}) interface{} {
+	r := reflect.ValueOf(v)
+	switch {
+	case !r.IsValid() || r.IsZero():
+		return d
+	case (r.Kind() == reflect.Slice || r.Kind() == reflect.Map) && r.Len() == 0:
+		return d
+	}
+	return v
+}
+
+// joinHelper writes the elements of the slice or the array v with Format, separated by sep.
+func joinHelper(v interface{}, sep string) string {
+	r := reflect.ValueOf(v)
+	for r.Kind() == reflect.Ptr || r.Kind() == reflect.Interface {
+		r = r.Elem()
+	}
+	if r.Kind() != reflect.Slice && r.Kind() != reflect.Array {
+		return Format(v)
+	}
+	elems := make([]string, r.Len())
+	for i := range elems {
+		elems[i] = formatValue(r.Index(i), nil)
+	}
+	return strings.Join(elems, sep)
+}
+
+// call passes v through the helper that s names, looking it up in the Helpers of the Engine
+// before the ones built in. It returns an invalid value if that fails.
+func (self *state) call(s stage, v reflect.Value) reflect.Value {
+	f, ok := self.helpers[s.name]
+	if !ok {
+		f = helpers[s.name]
+	}
+	args := make([]reflect.Value, len(s.args))
+	for i, arg := range s.args {
+		args[i] = arg.evaluate(self)
+	}
+	out, err := callHelper(self.ctx, s.name, f, v, args, self.formatters)
+	if err != nil {
+		self.fail(err)
+	}
+	return out
+}
+
+// Pipe passes v through the built-in helper name with args following it. It is used by the code
+// that GenerateGo writes for the stages of pipes.
+func Pipe(name string, v interface{}, args ...interface{}) (interface{}, error) {
+	values := make([]reflect.Value, len(args))
+	for i, arg := range args {
+		values[i] = reflect.ValueOf(arg)
+	}
+	out, err := callHelper(context.Background(), name, helpers[name], reflect.ValueOf(v), values, nil)
+	if err != nil || !out.IsValid() || !out.CanInterface() {
+		return nil, err
+	}
+	return out.Interface(), nil
+}
+
+var (
+	errorType   = reflect.TypeOf((*error)(nil)).Elem()
+	contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+)
+
+// callHelper calls the helper f of the given name with v followed by args, and preceded by ctx if
+// its first parameter is a context.Context. A helper returns its value and optionally an error.
+// Arguments that the helper takes as strings are given the text that the engine writes for them,
+// and numbers are converted to the type of number it takes.
+func callHelper(ctx context.Context, name string, f interface{}, v reflect.Value, args []reflect.Value, formatters map[reflect.Type]func(interface{}) string) (out reflect.Value, err error) {
+	if f == nil {
+		err = fmt.Errorf("gohaml: helper %s is not defined", name)
+		return
+	}
+	fn := reflect.ValueOf(f)
+	t := fn.Type()
+	if t.Kind() != reflect.Func || t.NumOut() < 1 || t.NumOut() > 2 || t.NumOut() == 2 && t.Out(1) != errorType {
+		err = fmt.Errorf("gohaml: helper %s must be a function that returns a value and optionally an error", name)
+		return
+	}
+	args = append([]reflect.Value{v}, args...)
+	first := 0
+	if t.NumIn() > 0 && t.In(0) == contextType {
+		args = append([]reflect.Value{reflect.ValueOf(ctx)}, args...)
+		first = 1
+	}
+	if n := t.NumIn(); len(args) != n && (!t.IsVariadic() || len(args) < n-1) {
+		err = fmt.Errorf("gohaml: helper %s takes %d arguments, not %d", name, n-first, len(args)-first)
+		return
+	}
+	for i := first; i < len(args); i++ {
+		arg := args[i]
+		var in reflect.Type
+		if last := t.NumIn() - 1; t.IsVariadic() && i >= last {
+			in = t.In(last).Elem()
+		} else {
+			in = t.In(i)
+		}
+		var ok bool
+		if args[i], ok = helperArg(arg, in, formatters); !ok {
+			err = fmt.Errorf("gohaml: cannot use %s as argument %d of helper %s, which takes %s", arg.Type(), i+1-first, name, in)
+			return
+		}
+	}
+	results := fn.Call(args)
+	if len(results) == 2 && !results[1].IsNil() {
+		err = fmt.Errorf("gohaml: helper %s: %s", name, results[1].Interface())
+		return
+	}
+	out = results[0]
+	return
+}
+
+// helperArg converts v to the type t of a parameter of a helper, reporting whether it can be.
+func helperArg(v reflect.Value, t reflect.Type, formatters map[reflect.Type]func(interface{}) string) (reflect.Value, bool) {
+	for v.Kind() == reflect.Interface && !v.IsNil() {
+		v = v.Elem()
+	}
+	switch {
+	case !v.IsValid() || v.Kind() == reflect.Interface:
+		return reflect.Zero(t), true
+	case v.Type().AssignableTo(t):
+		return v, true
+	case t.Kind() == reflect.String:
+		return reflect.ValueOf(formatValue(v, formatters)).Convert(t), true
+	case isNumber(v.Kind()) && isNumber(t.Kind()) && v.Type().ConvertibleTo(t):
+		return v.Convert(t), true
+	}
+	return v, false
+}
+
+func isNumber(k reflect.Kind) bool {
+	return reflect.Int <= k && k <= reflect.Complex128
+}
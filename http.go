@@ -13,19 +13,29 @@ import (
 // /bla/bla/dingdong.html -> ${base}/bla/bla/dingdong.haml
 // /bla/bla/              -> ${base}/bla/bla/index.haml
 func NewHamlHandler(base string) (hndl http.Handler, err error) {
+	return NewHamlHandlerGlobals(base, nil)
+}
+
+// NewHamlHandlerGlobals creates an http.Handler like NewHamlHandler does whose pages see globals,
+// like the name of the site or the host serving its assets. See Engine.Globals.
+func NewHamlHandlerGlobals(base string, globals map[string]interface{}) (hndl http.Handler, err error) {
 	var l Loader
 	if l, err = NewFileSystemLoader(base); err != nil {
 		return
 	}
-	return &httpHamlHandler{l}, nil
+	return &httpHamlHandler{WithGlobals(l, globals)}, nil
+}
+
+// NewHamlSetHandler creates an http.Handler like NewHamlHandlerGlobals does that serves the
+// templates of set, which were parsed when it was made, instead of loading them for every request.
+func NewHamlSetHandler(set *Set, globals map[string]interface{}) http.Handler {
+	return &httpHamlHandler{WithGlobals(set, globals)}
 }
 
 type httpHamlHandler struct {
 	loader Loader
 }
 
-var defaultScope map[string]interface{}
-
 func adjustSuffix(path string) string {
 	const htmlExt = ".html"
 	const htmExt = ".htm"
@@ -66,7 +76,7 @@ func (h *httpHamlHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	path = adjustSuffix(path)
 	if engine, err := h.loader.Load(path); err != nil {
 		http.NotFound(w, r)
-	} else {
-		w.Write(([]byte)(engine.Render(defaultScope)))
+	} else if err = engine.RenderContext(r.Context(), w, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 	}
 }
@@ -1,123 +1,251 @@
+// Code generated by goyacc -o lang.go lang.y. DO NOT EDIT.
+
 //line lang.y:2
 package gohaml
 
-import "fmt"
+import __yyfmt__ "fmt"
+
+//line lang.y:2
 
-var Output inode
+import (
+	"fmt"
+	"strings"
 
-//line lang.y:9
+	"github.com/realistschuckle/gohaml/ast"
+)
+
+//line lang.y:12
 type yySymType struct {
 	yys int
-	n   inode
+	n   ast.Node
 	s   string
 	i   interface{}
-	c   icodenode
+	e   ast.Expr
+	ss  []string
+	es  []ast.Expr
+	ls  []*ast.Local
+	ps  [][2]ast.Expr
+	st  *ast.Stage
 }
 
 const IDENT = 57346
-const ATOM = 57347
-const FOR = 57348
-const RANGE = 57349
-
-var yyToknames = []string{
+const SCRIPT = 57347
+const ATOM = 57348
+const FOR = 57349
+const RANGE = 57350
+const ELSE = 57351
+const BREAK = 57352
+const CONTINUE = 57353
+const SWITCH = 57354
+const CASE = 57355
+const DEFAULT = 57356
+const EXTENDS = 57357
+const BLOCK = 57358
+const CONTENT_FOR = 57359
+const DEF = 57360
+const RENDER = 57361
+const RENDER_EACH = 57362
+
+var yyToknames = [...]string{
+	"$end",
+	"error",
+	"$unk",
 	"IDENT",
+	"SCRIPT",
 	"ATOM",
 	"FOR",
 	"RANGE",
+	"ELSE",
+	"BREAK",
+	"CONTINUE",
+	"SWITCH",
+	"CASE",
+	"DEFAULT",
+	"EXTENDS",
+	"BLOCK",
+	"CONTENT_FOR",
+	"DEF",
+	"RENDER",
+	"RENDER_EACH",
+	"','",
+	"':'",
+	"'='",
+	"'('",
+	"')'",
+	"'+'",
+	"'|'",
+	"'['",
+	"']'",
+	"'{'",
+	"'}'",
+	"'.'",
 }
-var yyStatenames = []string{}
+
+var yyStatenames = [...]string{}
 
 const yyEofCode = 1
 const yyErrCode = 2
-const yyMaxDepth = 200
+const yyInitialStackSize = 16
 
-//line lang.y:66
+//line lang.y:275
 
 //line yacctab:1
-var yyExca = []int{
+var yyExca = [...]int8{
 	-1, 1,
 	1, -1,
 	-2, 0,
 }
 
-const yyNprod = 7
 const yyPrivate = 57344
 
-var yyTokenNames []string
-var yyStates []string
-
-const yyLast = 19
-
-var yyAct = []int{
-
-	13, 14, 15, 7, 12, 5, 6, 17, 3, 19,
-	2, 11, 10, 16, 8, 4, 9, 18, 1,
+const yyLast = 93
+
+var yyAct = [...]int8{
+	19, 52, 18, 39, 40, 38, 59, 58, 37, 27,
+	25, 80, 78, 51, 50, 28, 29, 84, 63, 48,
+	45, 46, 87, 27, 44, 83, 76, 61, 10, 2,
+	70, 3, 49, 4, 5, 6, 7, 8, 9, 11,
+	12, 13, 14, 16, 17, 21, 79, 20, 64, 60,
+	15, 65, 27, 47, 71, 72, 69, 53, 55, 89,
+	77, 74, 75, 73, 36, 41, 35, 32, 56, 22,
+	30, 23, 86, 82, 26, 81, 68, 62, 57, 34,
+	85, 33, 31, 24, 88, 43, 42, 67, 90, 66,
+	54, 1, 91,
 }
-var yyPact = []int{
 
-	4, -1000, 11, -4, -2, -7, 10, 7, -5, -1000,
-	-1000, -10, -8, -1000, 9, 0, -10, 5, -1000, -1000,
+var yyPact = [...]int16{
+	24, -32768, 41, 79, -32768, -32768, -32768, 41, 41, -32768,
+	-7, 64, 78, 61, 77, 75, 60, 58, -19, -32768,
+	-32768, -29, 41, 41, -1, -19, 32, -32768, -4, 41,
+	-32768, -32768, -32768, -10, -11, -32768, 36, 54, -32768, 74,
+	-22, 32, -25, 28, 5, 73, -5, 41, 41, -19,
+	72, 41, 9, 41, 41, -32768, -32768, -29, -32768, -32768,
+	41, 41, 4, 52, -32768, -19, -13, 25, -32768, -14,
+	71, -32768, -32768, -32768, 3, -32768, -6, 41, -32768, 68,
+	-32768, 0, 9, 41, 51, -19, -32768, 41, -32768, 41,
+	-32768, -19,
 }
-var yyPgo = []int{
 
-	0, 18, 16, 0,
+var yyPgo = [...]int8{
+	0, 91, 0, 2, 90, 5, 89, 87, 4, 65,
+	86, 85, 1,
 }
-var yyR1 = []int{
 
-	0, 1, 1, 2, 2, 3, 3,
+var yyR1 = [...]int8{
+	0, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+	1, 12, 12, 6, 6, 7, 7, 8, 8, 9,
+	9, 3, 3, 4, 4, 4, 2, 2, 2, 2,
+	10, 10, 11, 11, 5, 5,
 }
-var yyR2 = []int{
 
-	0, 8, 4, 1, 2, 3, 0,
+var yyR2 = [...]int8{
+	0, 2, 8, 6, 1, 1, 1, 2, 2, 1,
+	4, 3, 2, 2, 2, 5, 2, 5, 2, 3,
+	5, 0, 5, 0, 1, 1, 3, 0, 1, 1,
+	3, 1, 3, 1, 1, 2, 1, 2, 3, 3,
+	0, 1, 3, 5, 3, 0,
 }
-var yyChk = []int{
 
-	-1000, -1, 6, 4, 4, 9, 8, 10, 4, -2,
-	5, 4, 9, -3, 11, 10, 4, 7, -3, 4,
+var yyChk = [...]int16{
+	-32768, -1, 5, 7, 9, 10, 11, 12, 13, 14,
+	4, 15, 16, 17, 18, 26, 19, 20, -3, -2,
+	6, 4, 28, 30, 4, -3, -9, -2, 22, 23,
+	6, 4, 6, 4, 4, 6, 6, 27, -5, 32,
+	-8, -9, -10, -11, -2, 21, 22, 21, 23, -3,
+	24, 24, -12, 21, -4, 4, 14, 4, 29, 31,
+	21, 22, 4, 23, -2, -3, -6, -7, 4, -8,
+	21, -2, -2, -5, -2, -2, 22, 8, 25, 21,
+	25, 4, -12, 22, 23, -3, 4, 22, -2, 8,
+	-2, -3,
 }
-var yyDef = []int{
 
-	0, -2, 0, 0, 0, 0, 0, 0, 0, 2,
-	3, 6, 0, 4, 0, 0, 6, 0, 5, 1,
+var yyDef = [...]int8{
+	0, -2, 0, 0, 4, 5, 6, 0, 0, 9,
+	0, 0, 0, 0, 0, 0, 0, 0, 1, 31,
+	36, 45, 27, 40, 0, 7, 8, 29, 0, 0,
+	12, 13, 14, 16, 18, 21, 0, 0, 37, 0,
+	0, 28, 0, 41, 0, 0, 0, 0, 0, 11,
+	23, 27, 19, 0, 32, 33, 34, 45, 38, 39,
+	0, 0, 0, 0, 30, 10, 0, 24, 25, 0,
+	0, 21, 35, 44, 0, 42, 0, 0, 15, 0,
+	17, 0, 20, 0, 0, 3, 26, 0, 43, 0,
+	22, 2,
 }
-var yyTok1 = []int{
 
+var yyTok1 = [...]int8{
 	1, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
 	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
-	3, 3, 3, 3, 8, 3, 11, 3, 3, 3,
-	3, 3, 3, 3, 3, 3, 3, 3, 9, 3,
-	3, 10,
+	24, 25, 3, 26, 21, 3, 32, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 22, 3,
+	3, 23, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 28, 3, 29, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 3, 3, 3, 3, 3, 3, 3,
+	3, 3, 3, 30, 27, 31,
 }
-var yyTok2 = []int{
 
-	2, 3, 4, 5, 6, 7,
+var yyTok2 = [...]int8{
+	2, 3, 4, 5, 6, 7, 8, 9, 10, 11,
+	12, 13, 14, 15, 16, 17, 18, 19, 20,
 }
-var yyTok3 = []int{
+
+var yyTok3 = [...]int8{
 	0,
 }
 
+var yyErrorMessages = [...]struct {
+	state int
+	token int
+	msg   string
+}{}
+
 //line yaccpar:1
 
 /*	parser for yacc output	*/
 
-var yyDebug = 0
+var (
+	yyDebug        = 0
+	yyErrorVerbose = false
+)
 
 type yyLexer interface {
 	Lex(lval *yySymType) int
 	Error(s string)
 }
 
-const yyFlag = -1000
+type yyParser interface {
+	Parse(yyLexer) int
+	Lookahead() int
+}
+
+type yyParserImpl struct {
+	lval  yySymType
+	stack [yyInitialStackSize]yySymType
+	char  int
+}
+
+func (p *yyParserImpl) Lookahead() int {
+	return p.char
+}
+
+func yyNewParser() yyParser {
+	return &yyParserImpl{}
+}
+
+const yyFlag = -32768
 
 func yyTokname(c int) string {
-	if c > 0 && c <= len(yyToknames) {
+	if c >= 1 && c-1 < len(yyToknames) {
 		if yyToknames[c-1] != "" {
 			return yyToknames[c-1]
 		}
 	}
-	return fmt.Sprintf("tok-%v", c)
+	return __yyfmt__.Sprintf("tok-%v", c)
 }
 
 func yyStatname(s int) string {
@@ -126,54 +254,130 @@ func yyStatname(s int) string {
 			return yyStatenames[s]
 		}
 	}
-	return fmt.Sprintf("state-%v", s)
+	return __yyfmt__.Sprintf("state-%v", s)
 }
 
-func yylex1(lex yyLexer, lval *yySymType) int {
-	c := 0
-	char := lex.Lex(lval)
+func yyErrorMessage(state, lookAhead int) string {
+	const TOKSTART = 4
+
+	if !yyErrorVerbose {
+		return "syntax error"
+	}
+
+	for _, e := range yyErrorMessages {
+		if e.state == state && e.token == lookAhead {
+			return "syntax error: " + e.msg
+		}
+	}
+
+	res := "syntax error: unexpected " + yyTokname(lookAhead)
+
+	// To match Bison, suggest at most four expected tokens.
+	expected := make([]int, 0, 4)
+
+	// Look for shiftable tokens.
+	base := int(yyPact[state])
+	for tok := TOKSTART; tok-1 < len(yyToknames); tok++ {
+		if n := base + tok; n >= 0 && n < yyLast && int(yyChk[int(yyAct[n])]) == tok {
+			if len(expected) == cap(expected) {
+				return res
+			}
+			expected = append(expected, tok)
+		}
+	}
+
+	if yyDef[state] == -2 {
+		i := 0
+		for yyExca[i] != -1 || int(yyExca[i+1]) != state {
+			i += 2
+		}
+
+		// Look for tokens that we accept or reduce.
+		for i += 2; yyExca[i] >= 0; i += 2 {
+			tok := int(yyExca[i])
+			if tok < TOKSTART || yyExca[i+1] == 0 {
+				continue
+			}
+			if len(expected) == cap(expected) {
+				return res
+			}
+			expected = append(expected, tok)
+		}
+
+		// If the default action is to accept or reduce, give up.
+		if yyExca[i+1] != 0 {
+			return res
+		}
+	}
+
+	for i, tok := range expected {
+		if i == 0 {
+			res += ", expecting "
+		} else {
+			res += " or "
+		}
+		res += yyTokname(tok)
+	}
+	return res
+}
+
+func yylex1(lex yyLexer, lval *yySymType) (char, token int) {
+	token = 0
+	char = lex.Lex(lval)
 	if char <= 0 {
-		c = yyTok1[0]
+		token = int(yyTok1[0])
 		goto out
 	}
 	if char < len(yyTok1) {
-		c = yyTok1[char]
+		token = int(yyTok1[char])
 		goto out
 	}
 	if char >= yyPrivate {
 		if char < yyPrivate+len(yyTok2) {
-			c = yyTok2[char-yyPrivate]
+			token = int(yyTok2[char-yyPrivate])
 			goto out
 		}
 	}
 	for i := 0; i < len(yyTok3); i += 2 {
-		c = yyTok3[i+0]
-		if c == char {
-			c = yyTok3[i+1]
+		token = int(yyTok3[i+0])
+		if token == char {
+			token = int(yyTok3[i+1])
 			goto out
 		}
 	}
 
 out:
-	if c == 0 {
-		c = yyTok2[1] /* unknown char */
+	if token == 0 {
+		token = int(yyTok2[1]) /* unknown char */
 	}
 	if yyDebug >= 3 {
-		fmt.Printf("lex %U %s\n", uint(char), yyTokname(c))
+		__yyfmt__.Printf("lex %s(%d)\n", yyTokname(token), uint(char))
 	}
-	return c
+	return char, token
 }
 
 func yyParse(yylex yyLexer) int {
+	return yyNewParser().Parse(yylex)
+}
+
+func (yyrcvr *yyParserImpl) Parse(yylex yyLexer) int {
 	var yyn int
-	var yylval yySymType
 	var yyVAL yySymType
-	yyS := make([]yySymType, yyMaxDepth)
+	var yyDollar []yySymType
+	_ = yyDollar // silence set and not used
+	yyS := yyrcvr.stack[:]
 
 	Nerrs := 0   /* number of errors */
 	Errflag := 0 /* error recovery flag */
 	yystate := 0
-	yychar := -1
+	yyrcvr.char = -1
+	yytoken := -1 // yyrcvr.char translated into internal numbering
+	defer func() {
+		// Make sure we report no lookahead when not parsing.
+		yystate = -1
+		yyrcvr.char = -1
+		yytoken = -1
+	}()
 	yyp := -1
 	goto yystack
 
@@ -186,7 +390,7 @@ ret1:
 yystack:
 	/* put a state and value onto the stack */
 	if yyDebug >= 4 {
-		fmt.Printf("char %v in %v\n", yyTokname(yychar), yyStatname(yystate))
+		__yyfmt__.Printf("char %v in %v\n", yyTokname(yytoken), yyStatname(yystate))
 	}
 
 	yyp++
@@ -199,21 +403,22 @@ yystack:
 	yyS[yyp].yys = yystate
 
 yynewstate:
-	yyn = yyPact[yystate]
+	yyn = int(yyPact[yystate])
 	if yyn <= yyFlag {
 		goto yydefault /* simple state */
 	}
-	if yychar < 0 {
-		yychar = yylex1(yylex, &yylval)
+	if yyrcvr.char < 0 {
+		yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
 	}
-	yyn += yychar
+	yyn += yytoken
 	if yyn < 0 || yyn >= yyLast {
 		goto yydefault
 	}
-	yyn = yyAct[yyn]
-	if yyChk[yyn] == yychar { /* valid shift */
-		yychar = -1
-		yyVAL = yylval
+	yyn = int(yyAct[yyn])
+	if int(yyChk[yyn]) == yytoken { /* valid shift */
+		yyrcvr.char = -1
+		yytoken = -1
+		yyVAL = yyrcvr.lval
 		yystate = yyn
 		if Errflag > 0 {
 			Errflag--
@@ -223,27 +428,27 @@ yynewstate:
 
 yydefault:
 	/* default state action */
-	yyn = yyDef[yystate]
+	yyn = int(yyDef[yystate])
 	if yyn == -2 {
-		if yychar < 0 {
-			yychar = yylex1(yylex, &yylval)
+		if yyrcvr.char < 0 {
+			yyrcvr.char, yytoken = yylex1(yylex, &yyrcvr.lval)
 		}
 
 		/* look through exception table */
 		xi := 0
 		for {
-			if yyExca[xi+0] == -1 && yyExca[xi+1] == yystate {
+			if yyExca[xi+0] == -1 && int(yyExca[xi+1]) == yystate {
 				break
 			}
 			xi += 2
 		}
 		for xi += 2; ; xi += 2 {
-			yyn = yyExca[xi+0]
-			if yyn < 0 || yyn == yychar {
+			yyn = int(yyExca[xi+0])
+			if yyn < 0 || yyn == yytoken {
 				break
 			}
 		}
-		yyn = yyExca[xi+1]
+		yyn = int(yyExca[xi+1])
 		if yyn < 0 {
 			goto ret0
 		}
@@ -252,11 +457,11 @@ yydefault:
 		/* error ... attempt to resume parsing */
 		switch Errflag {
 		case 0: /* brand new error */
-			yylex.Error("syntax error")
+			yylex.Error(yyErrorMessage(yystate, yytoken))
 			Nerrs++
 			if yyDebug >= 1 {
-				fmt.Printf("%s", yyStatname(yystate))
-				fmt.Printf("saw %s\n", yyTokname(yychar))
+				__yyfmt__.Printf("%s", yyStatname(yystate))
+				__yyfmt__.Printf(" saw %s\n", yyTokname(yytoken))
 			}
 			fallthrough
 
@@ -265,17 +470,17 @@ yydefault:
 
 			/* find a state where "error" is a legal shift action */
 			for yyp >= 0 {
-				yyn = yyPact[yyS[yyp].yys] + yyErrCode
+				yyn = int(yyPact[yyS[yyp].yys]) + yyErrCode
 				if yyn >= 0 && yyn < yyLast {
-					yystate = yyAct[yyn] /* simulate a shift of "error" */
-					if yyChk[yystate] == yyErrCode {
+					yystate = int(yyAct[yyn]) /* simulate a shift of "error" */
+					if int(yyChk[yystate]) == yyErrCode {
 						goto yystack
 					}
 				}
 
 				/* the current p has no shift on "error", pop stack */
 				if yyDebug >= 2 {
-					fmt.Printf("error recovery pops state %d\n", yyS[yyp].yys)
+					__yyfmt__.Printf("error recovery pops state %d\n", yyS[yyp].yys)
 				}
 				yyp--
 			}
@@ -284,83 +489,340 @@ yydefault:
 
 		case 3: /* no shift yet; clobber input char */
 			if yyDebug >= 2 {
-				fmt.Printf("error recovery discards %s\n", yyTokname(yychar))
+				__yyfmt__.Printf("error recovery discards %s\n", yyTokname(yytoken))
 			}
-			if yychar == yyEofCode {
+			if yytoken == yyEofCode {
 				goto ret1
 			}
-			yychar = -1
+			yyrcvr.char = -1
+			yytoken = -1
 			goto yynewstate /* try again in the same state */
 		}
 	}
 
 	/* reduction by production yyn */
 	if yyDebug >= 2 {
-		fmt.Printf("reduce %v in:\n\t%v\n", yyn, yyStatname(yystate))
+		__yyfmt__.Printf("reduce %v in:\n\t%v\n", yyn, yyStatname(yystate))
 	}
 
 	yynt := yyn
 	yypt := yyp
 	_ = yypt // guard against "declared and not used"
 
-	yyp -= yyR2[yyn]
+	yyp -= int(yyR2[yyn])
+	// yyp is now the index of $0. Perform the default action. Iff the
+	// reduced production is ε, $1 is possibly out of range.
+	if yyp+1 >= len(yyS) {
+		nyys := make([]yySymType, len(yyS)*2)
+		copy(nyys, yyS)
+		yyS = nyys
+	}
 	yyVAL = yyS[yyp+1]
 
 	/* consult goto table to find next state */
-	yyn = yyR1[yyn]
-	yyg := yyPgo[yyn]
+	yyn = int(yyR1[yyn])
+	yyg := int(yyPgo[yyn])
 	yyj := yyg + yyS[yyp].yys + 1
 
 	if yyj >= yyLast {
-		yystate = yyAct[yyg]
+		yystate = int(yyAct[yyg])
 	} else {
-		yystate = yyAct[yyj]
-		if yyChk[yystate] != -yyn {
-			yystate = yyAct[yyg]
+		yystate = int(yyAct[yyj])
+		if int(yyChk[yystate]) != -yyn {
+			yystate = int(yyAct[yyg])
 		}
 	}
 	// dummy call; replaced with literal code
 	switch yynt {
 
 	case 1:
-		//line lang.y:25
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:39
 		{
-			rn := new(rangenode)
-			rn._lhs1 = yyS[yypt-6].s
-			rn._lhs2 = yyS[yypt-4].s
-			rn._rhs = res{yyS[yypt-0].s, true}
-			yyVAL.n = rn
-			Output = yyVAL.n
+			yyVAL.n = &ast.Script{X: yyDollar[2].e}
+			yylex.(*Lexer).output = yyVAL.n
 		}
 	case 2:
-		//line lang.y:34
+		yyDollar = yyS[yypt-8 : yypt+1]
+//line lang.y:44
 		{
-			yyS[yypt-0].c.setLHS(yyS[yypt-3].s)
-			yyVAL.n = yyS[yypt-0].c
-			Output = yyVAL.n
+			yyVAL.n = &ast.Range{Key: yyDollar[2].s, Value: yyDollar[4].s, X: yyDollar[8].e}
+			yylex.(*Lexer).output = yyVAL.n
 		}
 	case 3:
-		//line lang.y:42
+		yyDollar = yyS[yypt-6 : yypt+1]
+//line lang.y:49
 		{
-			dan := new(declassnode)
-			dan._rhs = yyS[yypt-0].i
-			yyVAL.c = dan
+			yyVAL.n = &ast.Range{Key: yyDollar[2].s, X: yyDollar[6].e}
+			yylex.(*Lexer).output = yyVAL.n
 		}
 	case 4:
-		//line lang.y:48
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:54
 		{
-			dan := new(vdeclassnode)
-			dan._rhs.value = yyS[yypt-1].s + yyS[yypt-0].s
-			dan._rhs.needsResolution = true
-			yyVAL.c = dan
+			yyVAL.n = &ast.Else{}
+			yylex.(*Lexer).output = yyVAL.n
 		}
 	case 5:
-		//line lang.y:57
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:59
 		{
-			yyVAL.s = fmt.Sprintf(".%s%s", yyS[yypt-1].s, yyS[yypt-0].s)
+			yyVAL.n = &ast.Break{}
+			yylex.(*Lexer).output = yyVAL.n
 		}
 	case 6:
-		//line lang.y:61
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:64
+		{
+			yyVAL.n = &ast.Continue{}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 7:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:69
+		{
+			yyVAL.n = &ast.Switch{X: yyDollar[2].e}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 8:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:74
+		{
+			yyVAL.n = &ast.Case{List: yyDollar[2].es}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 9:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:79
+		{
+			yyVAL.n = &ast.Case{}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 10:
+		yyDollar = yyS[yypt-4 : yypt+1]
+//line lang.y:84
+		{
+			yyVAL.n = &ast.Assign{Name: yyDollar[1].s, X: yyDollar[4].e, Define: true}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 11:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:89
+		{
+			yyVAL.n = &ast.Assign{Name: yyDollar[1].s, X: yyDollar[3].e}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 12:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:94
+		{
+			if name, ok := yyDollar[2].i.(string); ok {
+				yyVAL.n = &ast.Extends{Name: name}
+				yylex.(*Lexer).output = yyVAL.n
+			} else {
+				yylex.Error("the layout must be named by a string")
+			}
+		}
+	case 13:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:103
+		{
+			yyVAL.n = &ast.Block{Name: yyDollar[2].s}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 14:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:108
+		{
+			if name, ok := yyDollar[2].i.(string); ok {
+				yyVAL.n = &ast.ContentFor{Name: name}
+				yylex.(*Lexer).output = yyVAL.n
+			} else {
+				yylex.Error("the slot must be named by a string")
+			}
+		}
+	case 15:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line lang.y:117
+		{
+			yyVAL.n = &ast.Def{Name: yyDollar[2].s, Params: yyDollar[4].ss}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 16:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:122
+		{
+			yyVAL.n = &ast.Def{Name: yyDollar[2].s}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 17:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line lang.y:127
+		{
+			yyVAL.n = &ast.Call{Name: yyDollar[2].s, Args: yyDollar[4].es}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 18:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:132
+		{
+			yyVAL.n = &ast.Call{Name: yyDollar[2].s}
+			yylex.(*Lexer).output = yyVAL.n
+		}
+	case 19:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:137
+		{
+			if name, ok := yyDollar[2].i.(string); ok {
+				yyVAL.n = &ast.Render{Name: name, Locals: yyDollar[3].ls}
+				yylex.(*Lexer).output = yyVAL.n
+			} else {
+				yylex.Error("the partial must be named by a string")
+			}
+		}
+	case 20:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line lang.y:146
+		{
+			if name, ok := yyDollar[2].i.(string); ok {
+				yyVAL.n = &ast.Render{Name: name, Collection: yyDollar[4].e, Locals: yyDollar[5].ls}
+				yylex.(*Lexer).output = yyVAL.n
+			} else {
+				yylex.Error("the partial must be named by a string")
+			}
+		}
+	case 21:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line lang.y:157
+		{
+			yyVAL.ls = nil
+		}
+	case 22:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line lang.y:161
+		{
+			yyVAL.ls = append(yyDollar[1].ls, &ast.Local{Name: yyDollar[3].s, X: yyDollar[5].e})
+		}
+	case 23:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line lang.y:167
+		{
+			yyVAL.ss = nil
+		}
+	case 25:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:174
+		{
+			yyVAL.ss = []string{yyDollar[1].s}
+		}
+	case 26:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:178
+		{
+			yyVAL.ss = append(yyDollar[1].ss, yyDollar[3].s)
+		}
+	case 27:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line lang.y:184
+		{
+			yyVAL.es = nil
+		}
+	case 29:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:191
+		{
+			yyVAL.es = []ast.Expr{yyDollar[1].e}
+		}
+	case 30:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:195
+		{
+			yyVAL.es = append(yyDollar[1].es, yyDollar[3].e)
+		}
+	case 32:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:202
+		{
+			if p, ok := yyDollar[1].e.(*ast.Pipe); ok {
+				p.Stages = append(p.Stages, yyDollar[3].st)
+			} else {
+				yyVAL.e = &ast.Pipe{X: yyDollar[1].e, Stages: []*ast.Stage{yyDollar[3].st}}
+			}
+		}
+	case 33:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:212
+		{
+			yyVAL.st = &ast.Stage{Name: yyDollar[1].s}
+		}
+	case 34:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:216
+		{
+			yyVAL.st = &ast.Stage{Name: "default"}
+		}
+	case 35:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:220
+		{
+			yyDollar[1].st.Args = append(yyDollar[1].st.Args, yyDollar[2].e)
+		}
+	case 36:
+		yyDollar = yyS[yypt-1 : yypt+1]
+//line lang.y:226
+		{
+			yyVAL.e = &ast.Lit{Value: yyDollar[1].i}
+		}
+	case 37:
+		yyDollar = yyS[yypt-2 : yypt+1]
+//line lang.y:230
+		{
+			yyVAL.e = &ast.Path{Names: strings.Split(yyDollar[1].s+yyDollar[2].s, ".")}
+		}
+	case 38:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:234
+		{
+			yyVAL.e = &ast.SliceLit{Elems: yyDollar[2].es}
+		}
+	case 39:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:238
+		{
+			m := new(ast.MapLit)
+			for _, e := range yyDollar[2].ps {
+				m.Keys = append(m.Keys, e[0])
+				m.Values = append(m.Values, e[1])
+			}
+			yyVAL.e = m
+		}
+	case 40:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line lang.y:249
+		{
+			yyVAL.ps = nil
+		}
+	case 42:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:256
+		{
+			yyVAL.ps = [][2]ast.Expr{{yyDollar[1].e, yyDollar[3].e}}
+		}
+	case 43:
+		yyDollar = yyS[yypt-5 : yypt+1]
+//line lang.y:260
+		{
+			yyVAL.ps = append(yyDollar[1].ps, [2]ast.Expr{yyDollar[3].e, yyDollar[5].e})
+		}
+	case 44:
+		yyDollar = yyS[yypt-3 : yypt+1]
+//line lang.y:266
+		{
+			yyVAL.s = fmt.Sprintf(".%s%s", yyDollar[2].s, yyDollar[3].s)
+		}
+	case 45:
+		yyDollar = yyS[yypt-0 : yypt+1]
+//line lang.y:270
 		{
 			yyVAL.s = ""
 		}
@@ -1,58 +1,267 @@
 %{
 package gohaml
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
 
-var Output inode
+	"github.com/realistschuckle/gohaml/ast"
+)
 %}
 
 %union {
-  n inode
+  n ast.Node
   s string
   i interface{}
-  c icodenode
+  e ast.Expr
+  ss []string
+  es []ast.Expr
+  ls []*ast.Local
+  ps [][2]ast.Expr
+  st *ast.Stage
 }
 
 %type<n> statement
-%type<c> rhs
+%type<e> rhs expr
+%type<st> stage
 %type<s> complex_ident
+%type<ss> params param_list
+%type<es> args arg_list
+%type<ps> entries entry_list
+%type<ls> locals
 %token<s> IDENT
-%token<i> ATOM FOR RANGE
+%token SCRIPT
+%token<i> ATOM FOR RANGE ELSE BREAK CONTINUE SWITCH CASE DEFAULT EXTENDS BLOCK CONTENT_FOR DEF RENDER RENDER_EACH
 
 %%
 
-statement :  FOR IDENT ',' IDENT ':' '=' RANGE IDENT
+statement :  SCRIPT expr
             {
-              rn := new(rangenode)
-              rn._lhs1 = $2
-              rn._lhs2 = $4
-              rn._rhs = res{$8, true}
-              $$ = rn
-              Output = $$
+              $$ = &ast.Script{X: $2}
+              yylex.(*Lexer).output = $$
             }
-          | IDENT ':' '=' rhs
+          | FOR IDENT ',' IDENT ':' '=' RANGE expr
             {
-              $4.setLHS($1)
-              $$ = $4
-              Output = $$
+              $$ = &ast.Range{Key: $2, Value: $4, X: $8}
+              yylex.(*Lexer).output = $$
+            }
+          | FOR IDENT ':' '=' RANGE expr
+            {
+              $$ = &ast.Range{Key: $2, X: $6}
+              yylex.(*Lexer).output = $$
+            }
+          | ELSE
+            {
+              $$ = &ast.Else{}
+              yylex.(*Lexer).output = $$
+            }
+          | BREAK
+            {
+              $$ = &ast.Break{}
+              yylex.(*Lexer).output = $$
+            }
+          | CONTINUE
+            {
+              $$ = &ast.Continue{}
+              yylex.(*Lexer).output = $$
+            }
+          | SWITCH expr
+            {
+              $$ = &ast.Switch{X: $2}
+              yylex.(*Lexer).output = $$
+            }
+          | CASE arg_list
+            {
+              $$ = &ast.Case{List: $2}
+              yylex.(*Lexer).output = $$
+            }
+          | DEFAULT
+            {
+              $$ = &ast.Case{}
+              yylex.(*Lexer).output = $$
+            }
+          | IDENT ':' '=' expr
+            {
+              $$ = &ast.Assign{Name: $1, X: $4, Define: true}
+              yylex.(*Lexer).output = $$
+            }
+          | IDENT '=' expr
+            {
+              $$ = &ast.Assign{Name: $1, X: $3}
+              yylex.(*Lexer).output = $$
+            }
+          | EXTENDS ATOM
+            {
+              if name, ok := $2.(string); ok {
+                $$ = &ast.Extends{Name: name}
+                yylex.(*Lexer).output = $$
+              } else {
+                yylex.Error("the layout must be named by a string")
+              }
+            }
+          | BLOCK IDENT
+            {
+              $$ = &ast.Block{Name: $2}
+              yylex.(*Lexer).output = $$
+            }
+          | CONTENT_FOR ATOM
+            {
+              if name, ok := $2.(string); ok {
+                $$ = &ast.ContentFor{Name: name}
+                yylex.(*Lexer).output = $$
+              } else {
+                yylex.Error("the slot must be named by a string")
+              }
+            }
+          | DEF IDENT '(' params ')'
+            {
+              $$ = &ast.Def{Name: $2, Params: $4}
+              yylex.(*Lexer).output = $$
+            }
+          | DEF IDENT
+            {
+              $$ = &ast.Def{Name: $2}
+              yylex.(*Lexer).output = $$
+            }
+          | '+' IDENT '(' args ')'
+            {
+              $$ = &ast.Call{Name: $2, Args: $4}
+              yylex.(*Lexer).output = $$
+            }
+          | '+' IDENT
+            {
+              $$ = &ast.Call{Name: $2}
+              yylex.(*Lexer).output = $$
+            }
+          | RENDER ATOM locals
+            {
+              if name, ok := $2.(string); ok {
+                $$ = &ast.Render{Name: name, Locals: $3}
+                yylex.(*Lexer).output = $$
+              } else {
+                yylex.Error("the partial must be named by a string")
+              }
+            }
+          | RENDER_EACH ATOM ',' rhs locals
+            {
+              if name, ok := $2.(string); ok {
+                $$ = &ast.Render{Name: name, Collection: $4, Locals: $5}
+                yylex.(*Lexer).output = $$
+              } else {
+                yylex.Error("the partial must be named by a string")
+              }
             }
           ;
 
+locals :
+         {
+           $$ = nil
+         }
+       | locals ',' IDENT ':' rhs
+         {
+           $$ = append($1, &ast.Local{Name: $3, X: $5})
+         }
+       ;
+
+params :
+         {
+           $$ = nil
+         }
+       | param_list
+       ;
+
+param_list : IDENT
+             {
+               $$ = []string{$1}
+             }
+           | param_list ',' IDENT
+             {
+               $$ = append($1, $3)
+             }
+           ;
+
+args :
+       {
+         $$ = nil
+       }
+     | arg_list
+     ;
+
+arg_list : rhs
+           {
+             $$ = []ast.Expr{$1}
+           }
+         | arg_list ',' rhs
+           {
+             $$ = append($1, $3)
+           }
+         ;
+
+expr : rhs
+     | expr '|' stage
+       {
+         if p, ok := $1.(*ast.Pipe); ok {
+           p.Stages = append(p.Stages, $3)
+         } else {
+           $$ = &ast.Pipe{X: $1, Stages: []*ast.Stage{$3}}
+         }
+       }
+     ;
+
+stage : IDENT
+        {
+          $$ = &ast.Stage{Name: $1}
+        }
+      | DEFAULT
+        {
+          $$ = &ast.Stage{Name: "default"}
+        }
+      | stage rhs
+        {
+          $1.Args = append($1.Args, $2)
+        }
+      ;
+
 rhs : ATOM
       {
-        dan := new(declassnode)
-        dan._rhs = $1
-        $$ = dan
+        $$ = &ast.Lit{Value: $1}
       }
     | IDENT complex_ident
       {
-        dan := new(vdeclassnode)
-        dan._rhs.value = $1 + $2
-        dan._rhs.needsResolution = true
-        $$ = dan      
+        $$ = &ast.Path{Names: strings.Split($1 + $2, ".")}
+      }
+    | '[' args ']'
+      {
+        $$ = &ast.SliceLit{Elems: $2}
+      }
+    | '{' entries '}'
+      {
+        m := new(ast.MapLit)
+        for _, e := range $2 {
+          m.Keys = append(m.Keys, e[0])
+          m.Values = append(m.Values, e[1])
+        }
+        $$ = m
       }
     ;
 
+entries :
+          {
+            $$ = nil
+          }
+        | entry_list
+        ;
+
+entry_list : rhs ':' rhs
+             {
+               $$ = [][2]ast.Expr{{$1, $3}}
+             }
+           | entry_list ',' rhs ':' rhs
+             {
+               $$ = append($1, [2]ast.Expr{$3, $5})
+             }
+           ;
+
 complex_ident : '.' IDENT complex_ident
                 {
                   $$ = fmt.Sprintf(".%s%s", $2, $3)
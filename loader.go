@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"strings"
 )
 
@@ -45,6 +46,28 @@ func NewFileSystemLoader(dir string) (loader Loader, err error) {
 	return &fileSystemLoader{dir}, nil
 }
 
+// globalLoader sets the Globals of the engines that the loader it wraps loads.
+type globalLoader struct {
+	loader  Loader
+	globals map[string]interface{}
+}
+
+// WithGlobals returns a Loader that loads engines with loader and returns copies of them whose
+// Globals are globals and whose Loader is itself. The engines that loader returns, which a Set
+// shares between renders, are left alone.
+func WithGlobals(loader Loader, globals map[string]interface{}) Loader {
+	return &globalLoader{loader, globals}
+}
+
+func (l *globalLoader) Load(id interface{}) (engine *Engine, err error) {
+	if engine, err = l.loader.Load(id); err == nil {
+		engine = engine.clone()
+		engine.Globals = l.globals
+		engine.Loader = l
+	}
+	return
+}
+
 func (l *fileSystemLoader) Load(id_string interface{}) (engine *Engine, err error) {
 	// check
 	id, ok := id_string.(string)
@@ -53,10 +76,16 @@ func (l *fileSystemLoader) Load(id_string interface{}) (engine *Engine, err erro
 		return
 	}
 
+	// names taken from templates must not reach outside of the base directory
+	name := path.Clean(strings.TrimLeft(id, "/"))
+	if name == ".." || strings.HasPrefix(name, "../") {
+		err = fmt.Errorf("%s: outside of the template directory", id)
+		return
+	}
+
 	var file *os.File
 	// check fs
-	var path = l.baseDir + id
-	if file, err = os.Open(path); err != nil {
+	if file, err = os.Open(l.baseDir + name); err != nil {
 		return
 	}
 
@@ -67,5 +96,8 @@ func (l *fileSystemLoader) Load(id_string interface{}) (engine *Engine, err erro
 		return
 	}
 
-	return NewEngine(bb.String())
+	if engine, err = NewEngine(bb.String()); err == nil {
+		engine.Loader = l
+	}
+	return
 }
@@ -2,9 +2,12 @@ package gohaml
 
 import (
 	"bytes"
+	"context"
+	"io/ioutil"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -42,6 +45,20 @@ func TestLoadFile(t *testing.T) {
 	if fsl, err = NewFileSystemLoader("blsadfasdf"); err == nil {
 		t.Errorf("rats! expected error for non existing dir ...  ")
 	}
+
+	if fsl, err = NewFileSystemLoader(filepath.Join(test_dir, "layouts")); err != nil {
+		t.Fatalf("couldn't create fileSystemLoader: %s", err)
+	}
+	for _, name := range []string{"../" + simple_haml, "/../" + simple_haml, "a/../../" + simple_haml} {
+		if _, err = fsl.Load(name); err == nil {
+			t.Errorf("rats! expected error for %s, which is outside of the directory", name)
+		}
+	}
+	engine, _ := NewEngine("= render \"../../etc/passwd\"")
+	engine.Loader = fsl
+	if err = engine.Execute(new(bytes.Buffer), nil); err == nil {
+		t.Errorf("rats! expected error for a partial outside of the directory")
+	}
 }
 
 func readFile(t *testing.T, fn string) ([]byte, error) {
@@ -56,8 +73,6 @@ func readFile(t *testing.T, fn string) ([]byte, error) {
 	} else {
 		return data[:count], nil
 	}
-	// dead code
-	return nil, nil
 }
 
 func TestHttp(t *testing.T) {
@@ -98,6 +113,120 @@ func TestHttp(t *testing.T) {
 	}
 }
 
+func TestHttpLayout(t *testing.T) {
+	httpHandler, err := NewHamlHandler(test_dir)
+	if err != nil {
+		t.Fatalf("couldn't create HamlHandler: %s", err)
+	}
+	writer := &TestResponseWriter{bytes.NewBufferString(""), nil, 0}
+	request := http.Request{}
+	request.URL, _ = url.Parse("http://localhost/layout.html")
+	httpHandler.ServeHTTP(writer, &request)
+	expected := "<!DOCTYPE html>\n<html>\n\t<head>\n\t\t<title>\n\t\t\tLayout\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<h1>Hello</h1>\n\t\t<p>from a page</p>\n\t</body>\n</html>"
+	if writer.b.String() != expected {
+		t.Errorf("unexpected result. <%s> >%s<", writer.b.String(), expected)
+	}
+}
+
+func TestHttpCanceled(t *testing.T) {
+	httpHandler, err := NewHamlHandler(test_dir)
+	if err != nil {
+		t.Fatalf("couldn't create HamlHandler: %s", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	writer := &TestResponseWriter{bytes.NewBufferString(""), nil, 0}
+	request := (&http.Request{}).WithContext(ctx)
+	request.URL, _ = url.Parse("http://localhost/simple.html")
+	httpHandler.ServeHTTP(writer, request)
+	if writer.s != http.StatusInternalServerError {
+		t.Errorf("incorrect status: %d", writer.s)
+	}
+}
+
+func TestHttpGlobals(t *testing.T) {
+	httpHandler, err := NewHamlHandlerGlobals(test_dir, map[string]interface{}{"site": "Globals", "version": "1.0"})
+	if err != nil {
+		t.Fatalf("couldn't create HamlHandler: %s", err)
+	}
+	writer := &TestResponseWriter{bytes.NewBufferString(""), nil, 0}
+	request := http.Request{}
+	request.URL, _ = url.Parse("http://localhost/globals.html")
+	httpHandler.ServeHTTP(writer, &request)
+	expected := "<!DOCTYPE html>\n<html>\n\t<head>\n\t\t<title>\n\t\t\tGlobals\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<p>1.0</p>\n\t</body>\n</html>"
+	if writer.b.String() != expected {
+		t.Errorf("unexpected result. <%s> >%s<", writer.b.String(), expected)
+	}
+}
+
+func TestSet(t *testing.T) {
+	set, err := NewSet(test_dir)
+	if err != nil {
+		t.Fatalf("couldn't create Set: %s", err)
+	}
+	if set.Lookup("layout") == nil || set.Lookup("/layouts/site.haml") == nil || set.Lookup("missing") != nil {
+		t.Errorf("unexpected lookups")
+	}
+	var buf bytes.Buffer
+	if err = set.Render("layout.haml", &buf, nil); err != nil {
+		t.Errorf("couldn't render: %s", err)
+	}
+	expected := "<!DOCTYPE html>\n<html>\n\t<head>\n\t\t<title>\n\t\t\tLayout\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<h1>Hello</h1>\n\t\t<p>from a page</p>\n\t</body>\n</html>"
+	if buf.String() != expected {
+		t.Errorf("unexpected result. <%s> >%s<", buf.String(), expected)
+	}
+	if err = set.Render("missing", &buf, nil); err == nil {
+		t.Errorf("rats! expected error")
+	}
+
+	compiled := set.Lookup("globals").cache.compiled
+	if compiled == nil {
+		t.Errorf("expected the set to compile its templates")
+	}
+	writer := &TestResponseWriter{bytes.NewBufferString(""), nil, 0}
+	request := http.Request{}
+	request.URL, _ = url.Parse("http://localhost/globals.html")
+	NewHamlSetHandler(set, map[string]interface{}{"site": "Set", "version": "2.0"}).ServeHTTP(writer, &request)
+	expected = "<!DOCTYPE html>\n<html>\n\t<head>\n\t\t<title>\n\t\t\tSet\n\t\t</title>\n\t</head>\n\t<body>\n\t\t<p>2.0</p>\n\t</body>\n</html>"
+	if writer.b.String() != expected {
+		t.Errorf("unexpected result. <%s> >%s<", writer.b.String(), expected)
+	}
+	if engine := set.Lookup("globals"); engine.Globals != nil || engine.cache.compiled != compiled {
+		t.Errorf("the handler changed the engines of the set")
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gohaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "b"), 0755)
+	files := map[string]string{"a.haml": "%p\n%", "b/c.haml": "%\n%p\n  - i := ", "ok.haml": "%p ok", "d.txt": "%"}
+	for name, src := range files {
+		if err = ioutil.WriteFile(filepath.Join(dir, name), []byte(src), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	set, err := NewSet(dir)
+	list, ok := err.(ErrorList)
+	if set != nil || !ok || len(list) != 3 {
+		t.Fatalf("expected 3 errors but got %#v", err)
+	}
+	for i, pos := range []struct {
+		file string
+		line int
+	}{{"a.haml", 2}, {"b/c.haml", 1}, {"b/c.haml", 3}} {
+		if list[i].File != pos.file || list[i].Line != pos.line {
+			t.Errorf("expected error %d in %s on line %d but got %s", i, pos.file, pos.line, list[i])
+		}
+	}
+	if expected := "Syntax error in a.haml on line 2: Invalid tag: .\n"; list[0].Error() != expected {
+		t.Errorf("expected %q but got %q", expected, list[0].Error())
+	}
+}
+
 type TestResponseWriter struct {
 	b *bytes.Buffer
 	h http.Header
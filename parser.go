@@ -1,96 +1,398 @@
 package gohaml
 
 import (
-	"errors"
 	"fmt"
-	"os"
 	"strconv"
 	"strings"
 	"text/scanner"
 	"unicode"
+	"unicode/utf8"
+
+	"github.com/realistschuckle/gohaml/ast"
 )
 
+//go:generate goyacc -o lang.go lang.y
+
 type hamlParser struct {
 }
 
-func (self *hamlParser) parse(input string) (output *tree, err error) {
-	output = newTree()
-	var currentNode inode
-	var node inode
+// block is a node that is still open for nested content, along with the indentation of its line.
+type block struct {
+	indent int
+	node   ast.Node
+}
+
+// parse builds the syntax tree for input. Parsing continues past a line that holds a syntax
+// error, skipping the block nested beneath it, until maxErrors errors have been collected; a
+// maxErrors of zero or less collects them all. When errors occur, err is a sorted ErrorList.
+func (self *hamlParser) parse(input string, maxErrors int) (output *ast.File, err error) {
+	output = new(ast.File)
+	var errs ErrorList
+	var blocks []block
+	var node ast.Node
 	lastSpaceChar := '\000'
-	line := 1
-	j := 0
-	for i, r := range input {
-		if r == '\n' {
-			line += 1
-			node, err, lastSpaceChar = parseLeadingSpace(input[j:i], lastSpaceChar, line)
-			if err != nil {
-				return
+	unit := ""
+	badIndent := -1
+	extends := false
+	for i, text := range strings.Split(input, "\n") {
+		indent := len(text) - len(tl(text))
+		if badIndent >= 0 && (indent > badIndent || len(t(text)) == 0) {
+			continue
+		}
+		badIndent = -1
+		node, err, lastSpaceChar = parseLeadingSpace(text, lastSpaceChar, i+1)
+		if err == nil && node != nil {
+			err = checkIndent(blocks, node, text[:indent], &unit)
+		}
+		if _, ok := node.(*ast.Else); ok && err == nil {
+			if r, ok := last(siblings(blocks, indent, output)).(*ast.Range); !ok || r.Else != nil {
+				err = syntaxError(node.Pos(), "else must follow the body of a for loop.")
+			}
+		}
+		if sw, ok := enclosing(blocks, indent).(*ast.Switch); ok && err == nil {
+			if c, ok := node.(*ast.Case); !ok {
+				err = syntaxError(node.Pos(), "Illegal nesting: only case and default can be nested within a switch.")
+			} else if c.List == nil && hasDefault(sw) {
+				err = syntaxError(node.Pos(), "A switch can only have one default.")
 			}
-			if node != nil && !node.nil() {
-				putNodeInPlace(currentNode, node, output)
-				currentNode = node
+		} else if c, ok := node.(*ast.Case); ok && err == nil {
+			name := "case"
+			if c.List == nil {
+				name = "default"
 			}
-			j = i + 1
+			err = syntaxError(node.Pos(), "%s must be nested directly within a switch.", name)
+		}
+		if name := branchName(node); name != "" && err == nil && !inLoop(blocks, indent) {
+			err = syntaxError(node.Pos(), "%s must be nested within a for loop, directly or within a case.", name)
+		}
+		if _, ok := node.(*ast.Def); ok && err == nil && indent > 0 {
+			err = syntaxError(node.Pos(), "Illegal nesting: def must be at the top level of the template.")
+		}
+		if _, ok := node.(*ast.Extends); ok && err == nil {
+			if indent > 0 {
+				err = syntaxError(node.Pos(), "Illegal nesting: extends must be at the top level of the template.")
+			} else if extends {
+				err = syntaxError(node.Pos(), "A template can only extend one layout.")
+			} else {
+				extends = true
+			}
+		}
+		if err != nil {
+			if e, ok := err.(*Error); ok {
+				errs = append(errs, e)
+			} else {
+				errs.Add(i+1, indent+1, err.Error())
+			}
+			if maxErrors > 0 && len(errs) >= maxErrors {
+				break
+			}
+			badIndent = indent
+			continue
+		}
+		if node != nil {
+			blocks = putNodeInPlace(blocks, block{indent, node}, output)
 		}
 	}
-	node, err, lastSpaceChar = parseLeadingSpace(input[j:], lastSpaceChar, line)
-	if err != nil {
-		return
+	if len(errs) == 0 {
+		errs = append(checkCalls(output), checkDeclarations(output)...)
 	}
-	if node != nil && !node.nil() {
-		putNodeInPlace(currentNode, node, output)
+	if len(errs) > 0 {
+		errs.Sort()
+		if maxErrors > 0 && len(errs) > maxErrors {
+			errs = errs[:maxErrors]
+		}
+		err, output = errs, nil
 	}
 	return
 }
 
-func putNodeInPlace(cn inode, node inode, t *tree) {
-	if node == nil || node.nil() {
+// putNodeInPlace adds b.node to the innermost open block that is indented less than it, or to
+// the file, and returns the blocks that remain open afterwards. An else is attached to the range
+// before it instead.
+func putNodeInPlace(blocks []block, b block, f *ast.File) []block {
+	for len(blocks) > 0 && blocks[len(blocks)-1].indent >= b.indent {
+		blocks = blocks[:len(blocks)-1]
+	}
+	switch n := b.node.(type) {
+	case *ast.Else:
+		last(siblings(blocks, b.indent, f)).(*ast.Range).Else = n
+	case *ast.Case:
+		sw := enclosing(blocks, b.indent).(*ast.Switch)
+		sw.Cases = append(sw.Cases, n)
+	default:
+		nodes := siblings(blocks, b.indent, f)
+		*nodes = append(*nodes, b.node)
+	}
+	return append(blocks, b)
+}
+
+// inLoop reports whether a node indented by indent is nested within a range, directly or within
+// the cases of switches.
+func inLoop(blocks []block, indent int) bool {
+	for i := len(blocks) - 1; i >= 0; i-- {
+		if blocks[i].indent >= indent {
+			continue
+		}
+		switch blocks[i].node.(type) {
+		case *ast.Range:
+			return true
+		case *ast.Switch, *ast.Case:
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func hasDefault(sw *ast.Switch) bool {
+	for _, c := range sw.Cases {
+		if c.List == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// enclosing returns the innermost open block that a node indented by indent is nested in, or
+// nil at the top level.
+func enclosing(blocks []block, indent int) ast.Node {
+	for len(blocks) > 0 && blocks[len(blocks)-1].indent >= indent {
+		blocks = blocks[:len(blocks)-1]
+	}
+	if len(blocks) == 0 {
+		return nil
+	}
+	return blocks[len(blocks)-1].node
+}
+
+// siblings returns the list of nodes that a node indented by indent is added to.
+func siblings(blocks []block, indent int, f *ast.File) *[]ast.Node {
+	switch parent := enclosing(blocks, indent).(type) {
+	case nil:
+		return &f.Nodes
+	case *ast.Tag:
+		return &parent.Children
+	case *ast.Range:
+		return &parent.Body
+	case *ast.Else:
+		return &parent.Body
+	case *ast.Case:
+		return &parent.Body
+	case *ast.Block:
+		return &parent.Body
+	case *ast.ContentFor:
+		return &parent.Body
+	case *ast.Def:
+		return &parent.Body
+	case *ast.Call:
+		return &parent.Body
+	}
+	return nil
+}
+
+// last returns the last of nodes, or nil.
+func last(nodes *[]ast.Node) ast.Node {
+	if nodes == nil || len(*nodes) == 0 {
+		return nil
+	}
+	return (*nodes)[len(*nodes)-1]
+}
+
+// checkCalls reports the calls of mixins that are not defined in f or that do not pass as many
+// arguments as the mixin has parameters.
+func checkCalls(f *ast.File) (errs ErrorList) {
+	defs := make(map[string]*ast.Def)
+	for _, n := range f.Nodes {
+		if def, ok := n.(*ast.Def); ok {
+			defs[def.Name] = def
+		}
+	}
+	ast.Inspect(f, func(n ast.Node) bool {
+		if call, ok := n.(*ast.Call); ok {
+			pos := call.Position
+			if def, ok := defs[call.Name]; !ok {
+				errs.Add(pos.Line, pos.Column, fmt.Sprintf("Mixin \"%s\" is not defined.", call.Name))
+			} else if len(def.Params) != len(call.Args) {
+				errs.Add(pos.Line, pos.Column, fmt.Sprintf("Mixin \"%s\" takes %d arguments, but %d were given.", call.Name, len(def.Params), len(call.Args)))
+			}
+		}
+		return true
+	})
+	return
+}
+
+// checkDeclarations reports the names that are declared with := twice in the same block, and
+// the names that are assigned with = without being declared in the block or one enclosing it,
+// like Go does. The variables of a range loop are declared in a block enclosing its body, and the
+// parameters of a mixin in the block of its body, which sees none of the blocks outside it.
+func checkDeclarations(f *ast.File) (errs ErrorList) {
+	var check func(nodes []ast.Node, blocks []map[string]bool)
+	check = func(nodes []ast.Node, blocks []map[string]bool) {
+		declared := blocks[len(blocks)-1]
+		for _, n := range nodes {
+			switch n := n.(type) {
+			case *ast.Assign:
+				if n.Define && declared[n.Name] {
+					errs.Add(n.Position.Line, n.Position.Column, fmt.Sprintf("%s is already declared in this block.", n.Name))
+				} else if n.Define {
+					declared[n.Name] = true
+				} else if !isDeclared(n.Name, blocks) {
+					errs.Add(n.Position.Line, n.Position.Column, fmt.Sprintf("Cannot assign to %s, which is not declared.", n.Name))
+				}
+			case *ast.Range:
+				vars := map[string]bool{n.Key: true, n.Value: true}
+				check(n.Body, append(blocks, vars, make(map[string]bool)))
+				if n.Else != nil {
+					check(n.Else.Body, append(blocks, make(map[string]bool)))
+				}
+			case *ast.Switch:
+				for _, c := range n.Cases {
+					check(c.Body, append(blocks, make(map[string]bool)))
+				}
+			case *ast.Def:
+				params := make(map[string]bool)
+				for _, p := range n.Params {
+					params[p] = true
+				}
+				check(n.Body, []map[string]bool{params})
+			case *ast.Tag:
+				check(n.Children, blocks)
+			case *ast.Block:
+				check(n.Body, blocks)
+			case *ast.ContentFor:
+				check(n.Body, blocks)
+			case *ast.Call:
+				check(n.Body, blocks)
+			}
+		}
+	}
+	check(f.Nodes, []map[string]bool{make(map[string]bool)})
+	return
+}
+
+// isDeclared reports whether name is declared in one of blocks.
+func isDeclared(name string, blocks []map[string]bool) bool {
+	for _, declared := range blocks {
+		if declared[name] {
+			return true
+		}
+	}
+	return false
+}
+
+// branchName returns the keyword of n if it is a break or a continue, or "".
+func branchName(n ast.Node) string {
+	switch n.(type) {
+	case *ast.Break:
+		return "break"
+	case *ast.Continue:
+		return "continue"
+	}
+	return ""
+}
+
+// checkIndent validates the leading whitespace of node, which follows the last of blocks in the
+// document, the way Ruby HAML does. The first indented line of the document sets the
+// indentation unit that every other line has to use, no line may be indented more than one level
+// deeper than the line before it, and nodes that cannot hold children must not have any nested
+// beneath them.
+func checkIndent(blocks []block, node ast.Node, space string, unit *string) (err error) {
+	if len(space) == 0 {
 		return
 	}
-	if cn == nil || cn.nil() {
-		//t.nodes.Push(node)
-		t.nodes = append(t.nodes, node)
-	} else if node.indentLevel() < cn.indentLevel() {
-		for cn = cn.parent(); cn != nil && node.indentLevel() < cn.indentLevel(); cn = cn.parent() {
+	if len(*unit) == 0 {
+		*unit = space
+	}
+	if len(space)%len(*unit) != 0 {
+		return syntaxError(node.Pos(), "Inconsistent indentation: %s used for indentation, but the rest of the document was indented using %s.", humanIndent(space), humanIndent(*unit))
+	}
+	if len(blocks) == 0 {
+		return
+	}
+	cn := blocks[len(blocks)-1]
+	level, parentLevel := len(space)/len(*unit), cn.indent/len(*unit)
+	if level > parentLevel+1 {
+		return syntaxError(node.Pos(), "The line was indented %d levels deeper than the previous line.", level-parentLevel)
+	}
+	if level == parentLevel+1 {
+		if msg := illegalNesting(cn.node); msg != "" {
+			return syntaxError(node.Pos(), "Illegal nesting: %s", msg)
 		}
-		putNodeInPlace(cn, node, t)
-	} else if node.indentLevel() == cn.indentLevel() && cn.parent() != nil {
-		cn.parent().addChild(node)
-	} else if node.indentLevel() == cn.indentLevel() {
-		//t.nodes.Push(node)
-		t.nodes = append(t.nodes, node)
-	} else if node.indentLevel() > cn.indentLevel() {
-		cn.addChild(node)
 	}
+	return
+}
+
+// illegalNesting explains why n cannot hold nested content, or returns "" if it can.
+func illegalNesting(n ast.Node) string {
+	switch t := n.(type) {
+	case *ast.Doctype:
+		return "nesting within a header command is illegal."
+	case *ast.Tag:
+		switch {
+		case t.SelfClosing:
+			return "nesting within a self-closing tag is illegal."
+		case t.Inline != nil:
+			name := t.Name
+			if len(name) == 0 {
+				name = "div"
+			}
+			return fmt.Sprintf("content can't be both given on the same line as %%%s and nested within it.", name)
+		}
+	case *ast.Script, *ast.Yield, *ast.Content, *ast.Render:
+		return "nesting within a script line is illegal."
+	case *ast.Extends:
+		return "nesting within extends is illegal."
+	case *ast.Text:
+		return "nesting within plain text is illegal."
+	case *ast.Assign:
+		return "nesting within an assignment is illegal."
+	case *ast.Break, *ast.Continue:
+		return "nesting within break or continue is illegal."
+	}
+	return ""
+}
+
+func humanIndent(space string) string {
+	name := "space"
+	if space[0] == '\t' {
+		name = "tab"
+	}
+	if len(space) != 1 {
+		name += "s"
+	}
+	return fmt.Sprintf("%d %s", len(space), name)
 }
 
 var parser hamlParser
 
-func parseLeadingSpace(input string, lastSpaceChar rune, line int) (output inode, err error, spaceChar rune) {
-	node := new(node)
+func parseLeadingSpace(input string, lastSpaceChar rune, line int) (output ast.Node, err error, spaceChar rune) {
 	for i, r := range input {
+		pos := ast.Pos{Line: line, Column: i + 1}
 		switch {
-		case r == '!' && len(input) >= i+2 && input[i+1] == '!' && input[i+2] == '!':
-			if len(input) > i+2 {
-				output = parseDoctype(input[i+3:], node, line)
-			} else {
-				output = parseDoctype("", node, line)
-			}
+		case strings.HasPrefix(input[i:], "!!!"):
+			output = parseDoctype(input[i+3:], pos)
 		case r == '-':
-			output = parseCode(input[i+1:], node, line)
+			output, err = parseCode(input[i+1:], pos)
+		case r == '+' && isCall(input[i+1:]):
+			output, err = parseCode(input[i:], pos)
 		case r == '%':
-			output, err = parseTag(input[i+1:], node, true, line)
+			output, err = parseTag(input[i+1:], &ast.Tag{Position: pos}, true, pos)
 		case r == '#':
-			output, err = parseId(input[i+1:], node, line)
+			output, err = parseId(input[i+1:], &ast.Tag{Position: pos}, pos)
 		case r == '.':
-			output, err = parseClass(input[i+1:], node, line)
+			output, err = parseClass(input[i+1:], &ast.Tag{Position: pos}, pos)
+		case r == '=' && t(input[i+1:]) == "yield":
+			output = &ast.Yield{Position: pos}
+		case r == '=' && isContent(input[i+1:]):
+			output, err = parseContent(t(input[i+1:])[len("content "):], pos)
+		case r == '=' && isRender(input[i+1:]):
+			output, err = parseCode(input[i+1:], pos)
 		case r == '=':
-			output = parseKey(tl(input[i+1:]), node, line)
+			output, err = parseScript(tl(input[i+1:]), pos)
 		case r == '\\':
-			output = parseRemainder(input[i+1:], node, line)
+			output = parseText(input[i+1:], pos)
 		case !unicode.IsSpace(r):
-			output = parseRemainder(input[i:], node, line)
+			output = parseText(input[i:], pos)
 		case unicode.IsSpace(r):
 			if lastSpaceChar > 0 && r != lastSpaceChar {
 				from, to := "space", "tab"
@@ -98,17 +400,12 @@ func parseLeadingSpace(input string, lastSpaceChar rune, line int) (output inode
 					from = "tab"
 					to = "space"
 				}
-				msg := fmt.Sprintf("Syntax error on line %d: Inconsistent spacing in document changed from %s to %s characters.\n", line, from, to)
-				err = errors.New(msg)
+				err = syntaxError(pos, "Inconsistent spacing in document changed from %s to %s characters.", from, to)
 			} else {
 				lastSpaceChar = r
 			}
 		}
-		if nil != err {
-			break
-		}
-		if nil != output {
-			output.setIndentLevel(i)
+		if nil != err || nil != output {
 			break
 		}
 	}
@@ -116,71 +413,131 @@ func parseLeadingSpace(input string, lastSpaceChar rune, line int) (output inode
 	return
 }
 
-func parseDoctype(input string, n *node, line int) (output inode) {
-	output = n
-	n._name = "doctype"
-	if len(input) > 0 {
-		output = parseRemainder(input, n, line)
+func parseDoctype(input string, pos ast.Pos) (output ast.Node) {
+	output = &ast.Doctype{Position: pos, Type: strings.TrimSpace(input)}
+	return
+}
+
+// parseScript parses the input of a script. Scripts without a pipe look up their text as a path,
+// like they always have; the code of a script with one is parsed like that of a code line.
+func parseScript(input string, pos ast.Pos) (output *ast.Script, err error) {
+	input, noNewline := trimNoNewline(input)
+	x := pathExpr(input)
+	if strings.Contains(input, "|") {
+		lexer := newLexer(input)
+		lexer.start = SCRIPT
+		if yyParse(lexer) != 0 || lexer.output == nil || lexer.err != "" {
+			err = syntaxError(pos, "Did not recognize script \"%s\": %s.", t(input), lexer.err)
+			return
+		}
+		x = lexer.output.(*ast.Script).X
 	}
+	output = &ast.Script{Position: pos, X: x, NoNewline: noNewline}
 	return
 }
 
-func parseKey(input string, n *node, line int) (output inode) {
-	if input[len(input)-1] == '<' {
-		n = parseNoNewline("", n, line)
-		n.setRemainder(input[0:len(input)-1], true)
-	} else {
-		n.setRemainder(input, true)
-		output = n
+func parseContent(input string, pos ast.Pos) (output ast.Node, err error) {
+	name, e := strconv.Unquote(t(input))
+	if e != nil {
+		err = syntaxError(pos, "content needs the name of a slot as a string.")
+		return
+	}
+	output = &ast.Content{Position: pos, Name: name}
+	return
+}
+
+// isContent reports whether the script input writes a slot, naming it with a quoted string.
+func isContent(input string) bool {
+	return isQuotedAfter(input, "content")
+}
+
+// isCall reports whether input, which follows a '+', starts with the name of a mixin.
+func isCall(input string) bool {
+	r, _ := utf8.DecodeRuneInString(input)
+	return r == '_' || unicode.IsLetter(r)
+}
+
+// isRender reports whether the script input renders a partial, naming it with a quoted string.
+func isRender(input string) bool {
+	return isQuotedAfter(input, "render") || isQuotedAfter(input, "render_each")
+}
+
+// isQuotedAfter reports whether input is the given word followed by space and a quoted string.
+// Scripts that use the word otherwise look it up in the scope.
+func isQuotedAfter(input string, word string) bool {
+	rest := strings.TrimPrefix(tl(input), word)
+	if rest == tl(input) || tl(rest) == rest {
+		return false
+	}
+	return strings.HasPrefix(tl(rest), "\"") || strings.HasPrefix(tl(rest), "`")
+}
+
+func parseText(input string, pos ast.Pos) (output *ast.Text) {
+	input, noNewline := trimNoNewline(input)
+	output = &ast.Text{Position: pos, Text: input, NoNewline: noNewline}
+	return
+}
+
+func parseKey(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node, err error) {
+	script, err := parseScript(input, pos)
+	if err != nil {
+		return
 	}
-	output = n
+	tag.NoNewline = tag.NoNewline || script.NoNewline
+	script.NoNewline = false
+	tag.Inline = script
+	output = tag
 	return
 }
 
-func parseTag(input string, node *node, newTag bool, line int) (output inode, err error) {
+func parseTag(input string, tag *ast.Tag, newTag bool, pos ast.Pos) (output ast.Node, err error) {
 	if 0 == len(input) && newTag {
-		err = errors.New(fmt.Sprintf("Syntax error on line %d: Invalid tag: %s.\n", line, input))
+		err = syntaxError(pos, "Invalid tag: %s.", input)
 		return
 	}
 	for i, r := range input {
 		switch {
 		case r == '.':
-			output, err = parseClass(input[i+1:], node, line)
+			output, err = parseClass(input[i+1:], tag, pos)
 		case r == '#':
-			output, err = parseId(input[i+1:], node, line)
+			output, err = parseId(input[i+1:], tag, pos)
 		case r == '{':
-			output, err = parseAttributes(tl(input[i+1:]), node, line)
+			output, err = parseAttributes(tl(input[i+1:]), tag, pos)
 		case r == '<':
-			output = parseNoNewline(input[i+1:], node, line)
+			output = parseNoNewline(input[i+1:], tag, pos)
 		case r == '=':
-			output = parseKey(tl(input[i+1:]), node, line)
+			output, err = parseKey(tl(input[i+1:]), tag, pos)
 		case r == '/':
-			output = parseAutoclose("", node, line)
+			output = parseAutoclose("", tag, pos)
 		case unicode.IsSpace(r):
-			output = parseRemainder(input[i+1:], node, line)
+			output = parseRemainder(input[i+1:], tag, pos)
 		}
 		if nil != err {
 			break
 		}
 		if nil != output {
-			node._name = input[0:i]
+			if newTag {
+				tag.Name = input[0:i]
+			}
 			break
 		}
 	}
 	if nil == output {
-		node._name = input
-		output = node
+		if newTag {
+			tag.Name = input
+		}
+		output = tag
 	}
 	return
 }
 
-func parseAutoclose(input string, node *node, line int) (output inode) {
-	node._autoclose = true
-	output = node
+func parseAutoclose(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node) {
+	tag.SelfClosing = true
+	output = tag
 	return
 }
 
-func parseAttributes(input string, node *node, line int) (output inode, err error) {
+func parseAttributes(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node, err error) {
 	inKey := true
 	inRocket := false
 	keyEnd, attrStart := 0, 0
@@ -193,39 +550,34 @@ func parseAttributes(input string, node *node, line int) (output inode, err erro
 			inRocket = false
 			attrStart = i
 		} else if r == ',' {
-			node.addAttr(t(input[0:keyEnd]), t(input[attrStart:i]))
-			output, err = parseAttributes(tl(input[i+1:]), node, line)
+			addAttr(tag, t(input[0:keyEnd]), t(input[attrStart:i]))
+			output, err = parseAttributes(tl(input[i+1:]), tag, pos)
 			break
 		} else if r == '}' {
 			if attrStart == 0 {
-				msg := fmt.Sprintf("Syntax error on line %d: Attribute requires a value.\n", line)
-				//err = os.NewError(msg)
-				err = errors.New(msg)
+				err = syntaxError(pos, "Attribute requires a value.")
 				return
 			}
 			if inKey {
-				msg := fmt.Sprintf("Syntax error on line %d: Attribute requires a rocket and value.\n", line)
-				err = errors.New(msg)
+				err = syntaxError(pos, "Attribute requires a rocket and value.")
 				return
 			}
 			attrValue := t(input[attrStart:i])
-			node.addAttr(input[0:keyEnd], attrValue)
-			output, _ = parseTag(input[i+1:], node, false, line)
+			addAttr(tag, input[0:keyEnd], attrValue)
+			output, _ = parseTag(input[i+1:], tag, false, pos)
 			break
 		}
 	}
 	if nil == output {
-		msg := fmt.Sprintf("Syntax error on line %d: Attributes must have closing '}'.\n", line)
-		err = errors.New(msg)
+		err = syntaxError(pos, "Attributes must have closing '}'.")
 	}
 	return
 }
 
-func parseId(input string, node *node, line int) (output inode, err error) {
+func parseId(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node, err error) {
 	defer func() {
 		if nil == output {
-			msg := fmt.Sprintf("Syntax error on line %d: Illegal element: classes and ids must have values.\n", line)
-			err = errors.New(msg)
+			err = syntaxError(pos, "Illegal element: classes and ids must have values.")
 		}
 	}()
 	if len(input) == 0 {
@@ -236,34 +588,33 @@ func parseId(input string, node *node, line int) (output inode, err error) {
 			if i == 0 {
 				return
 			}
-			node.addAttrNoLookup("id", input[0:i])
+			addAttrNoLookup(tag, "id", input[0:i])
 		}
 		switch {
 		case r == '.':
-			output, _ = parseClass(input[i+1:], node, line)
+			output, _ = parseClass(input[i+1:], tag, pos)
 		case r == '=':
-			output = parseKey(tl(input[i+1:]), node, line)
+			output, err = parseKey(tl(input[i+1:]), tag, pos)
 		case r == '{':
-			output, err = parseAttributes(tl(input[i+1:]), node, line)
+			output, err = parseAttributes(tl(input[i+1:]), tag, pos)
 		case unicode.IsSpace(r):
-			output = parseRemainder(input[i+1:], node, line)
+			output = parseRemainder(input[i+1:], tag, pos)
 		}
 		if nil != output {
 			break
 		}
 	}
 	if nil == output {
-		output = node
-		node.addAttrNoLookup("id", input)
+		output = tag
+		addAttrNoLookup(tag, "id", input)
 	}
 	return
 }
 
-func parseClass(input string, node *node, line int) (output inode, err error) {
+func parseClass(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node, err error) {
 	defer func() {
 		if nil == output {
-			msg := fmt.Sprintf("Syntax error on line %d: Illegal element: classes and ids must have values.\n", line)
-			err = errors.New(msg)
+			err = syntaxError(pos, "Illegal element: classes and ids must have values.")
 		}
 	}()
 	if len(input) == 0 {
@@ -274,48 +625,81 @@ func parseClass(input string, node *node, line int) (output inode, err error) {
 			if i == 0 {
 				return
 			}
-			node.addAttrNoLookup("class", input[0:i])
+			addAttrNoLookup(tag, "class", input[0:i])
 		}
 		switch {
 		case r == '{':
-			output, err = parseAttributes(tl(input[i+1:]), node, line)
+			output, err = parseAttributes(tl(input[i+1:]), tag, pos)
 		case r == '.':
-			output, err = parseClass(input[i+1:], node, line)
+			output, err = parseClass(input[i+1:], tag, pos)
 		case r == '=':
-			output = parseKey(tl(input[i+1:]), node, line)
+			output, err = parseKey(tl(input[i+1:]), tag, pos)
 		case unicode.IsSpace(r):
-			output = parseRemainder(input[i+1:], node, line)
+			output = parseRemainder(input[i+1:], tag, pos)
 		}
 		if nil != output {
 			break
 		}
 	}
 	if nil == output {
-		node.addAttrNoLookup("class", input)
-		output = node
+		addAttrNoLookup(tag, "class", input)
+		output = tag
 	}
 	return
 }
 
-func parseRemainder(input string, node *node, line int) (output inode) {
-	if input[len(input)-1] == '<' {
-		node = parseNoNewline("", node, line)
-		node._remainder.value = input[0 : len(input)-1]
-		node._remainder.needsResolution = false
-	} else {
-		node._remainder.value = input
-		node._remainder.needsResolution = false
+func parseRemainder(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node) {
+	text := parseText(input, pos)
+	tag.NoNewline = tag.NoNewline || text.NoNewline
+	text.NoNewline = false
+	if len(text.Text) > 0 {
+		tag.Inline = text
 	}
-	output = node
+	output = tag
+	return
+}
+
+func parseNoNewline(input string, tag *ast.Tag, pos ast.Pos) (output ast.Node) {
+	tag.NoNewline = true
+	output = tag
 	return
 }
 
-func parseNoNewline(input string, node *node, line int) (output *node) {
-	node.setNoNewline(true)
-	output = node
+// trimNoNewline removes the '<' that ends a line which must not be followed by a newline.
+func trimNoNewline(input string) (output string, noNewline bool) {
+	output = input
+	if noNewline = strings.HasSuffix(input, "<"); noNewline {
+		output = input[0 : len(input)-1]
+	}
 	return
 }
 
+func addAttr(tag *ast.Tag, key string, value string) {
+	var k, v ast.Expr
+	if key[0] == ':' {
+		k = &ast.Lit{Value: key[1:]}
+	} else {
+		k = pathExpr(key)
+	}
+	switch {
+	case value == "true" || value == "false":
+		v = &ast.Lit{Value: value == "true"}
+	case value[0] == '"':
+		v = &ast.Lit{Value: value[1 : len(value)-1]}
+	default:
+		v = pathExpr(value)
+	}
+	tag.Attrs = append(tag.Attrs, &ast.Attr{Key: k, Value: v})
+}
+
+func addAttrNoLookup(tag *ast.Tag, key string, value string) {
+	tag.Attrs = append(tag.Attrs, &ast.Attr{Key: &ast.Lit{Value: key}, Value: &ast.Lit{Value: value}})
+}
+
+func pathExpr(input string) ast.Expr {
+	return &ast.Path{Names: strings.Split(t(input), ".")}
+}
+
 func t(input string) (output string) {
 	output = strings.Trim(input, " 	")
 	return
@@ -326,52 +710,145 @@ func tl(input string) (output string) {
 	return
 }
 
-func parseCode(input string, node inode, line int) (output inode) {
-	l.init(strings.NewReader(input))
-
-	success := yyParse(l)
-	if success != 0 {
-		fmt.Fprintf(os.Stderr, "Did not recognize %s", input)
+func parseCode(input string, pos ast.Pos) (output ast.Node, err error) {
+	lexer := newLexer(input)
+	if yyParse(lexer) != 0 || lexer.output == nil || lexer.err != "" {
+		err = syntaxError(pos, "Did not recognize code \"%s\": %s.", t(input), lexer.err)
+		return
+	}
+	output = lexer.output
+	switch n := output.(type) {
+	case *ast.Range:
+		n.Position = pos
+	case *ast.Else:
+		n.Position = pos
+	case *ast.Break:
+		n.Position = pos
+	case *ast.Continue:
+		n.Position = pos
+	case *ast.Switch:
+		n.Position = pos
+	case *ast.Case:
+		n.Position = pos
+	case *ast.Assign:
+		n.Position = pos
+	case *ast.Extends:
+		n.Position = pos
+	case *ast.Block:
+		n.Position = pos
+	case *ast.ContentFor:
+		n.Position = pos
+	case *ast.Def:
+		n.Position = pos
+	case *ast.Call:
+		n.Position = pos
+	case *ast.Render:
+		n.Position = pos
 	}
-	output = Output
 	return
 }
 
-// var s scanner.Scanner
-
+// Lexer feeds the tokens of a single line of code to the parser generated from lang.y. A new
+// Lexer is used for every line so that templates can be parsed concurrently.
 type Lexer struct {
-	s *scanner.Scanner
+	s      *scanner.Scanner
+	start  int
+	output ast.Node
+	err    string
 }
 
-var l = &Lexer{new(scanner.Scanner)}
-
-func (l *Lexer) init(reader *strings.Reader) {
-	l.s.Init(reader)
+func newLexer(input string) (l *Lexer) {
+	l = &Lexer{s: new(scanner.Scanner)}
+	l.s.Init(strings.NewReader(input))
+	l.s.Error = func(s *scanner.Scanner, msg string) {
+		l.Error(msg)
+	}
+	return
 }
 
 func (l *Lexer) Lex(v *yySymType) (output int) {
+	if output, l.start = l.start, 0; output != 0 {
+		return
+	}
 	i := l.s.Scan()
 	switch i {
 	case scanner.Ident:
-		switch l.s.TokenText() {
+		text := l.s.TokenText()
+		switch text {
 		case "for":
 			output = FOR
 		case "range":
 			output = RANGE
+		case "else":
+			output = ELSE
+		case "break":
+			output = BREAK
+		case "continue":
+			output = CONTINUE
+		case "switch":
+			output = SWITCH
+		case "case":
+			output = CASE
+		case "default":
+			output = DEFAULT
+		case "extends":
+			output = EXTENDS
+		case "block":
+			output = BLOCK
+		case "content_for":
+			output = CONTENT_FOR
+		case "def":
+			output = DEF
+		case "render", "render_each":
+			// Like in scripts, render is a keyword only when the quoted name of a partial
+			// follows it, and a name to look up otherwise.
+			output = IDENT
+			for l.s.Peek() == ' ' || l.s.Peek() == '\t' {
+				l.s.Next()
+			}
+			if p := l.s.Peek(); p == '"' || p == '`' {
+				output = map[string]int{"render": RENDER, "render_each": RENDER_EACH}[text]
+			}
+		case "true", "false":
+			output = ATOM
+			v.i = text == "true"
+			return
+		case "nil":
+			output = ATOM
+			v.i = nil
+			return
 		default:
 			output = IDENT
 		}
-		v.s = l.s.TokenText()
+		v.s = text
 	case scanner.String, scanner.RawString:
 		output = ATOM
 		text := l.s.TokenText()
-		v.i = text[1 : len(text)-1]
+		s, err := strconv.Unquote(text)
+		if err != nil {
+			l.Error("invalid string literal " + text)
+		}
+		v.i = s
 	case scanner.Int:
 		output = ATOM
-		v.i, _ = strconv.Atoi(l.s.TokenText())
+		var err error
+		if v.i, err = strconv.Atoi(l.s.TokenText()); err != nil {
+			l.Error("invalid int literal " + l.s.TokenText())
+		}
 	case scanner.Float:
 		output = ATOM
-		v.i, _ = strconv.ParseFloat(l.s.TokenText(), 64)
+		var err error
+		if v.i, err = strconv.ParseFloat(l.s.TokenText(), 64); err != nil {
+			l.Error("invalid float literal " + l.s.TokenText())
+		}
+	case scanner.Char:
+		output = ATOM
+		text := l.s.TokenText()
+		r, _, tail, err := strconv.UnquoteChar(text[1:len(text)-1], '\'')
+		if err != nil || tail != "" {
+			l.Error("invalid rune literal " + text)
+		}
+		v.i = r
 	case scanner.EOF:
 		output = 0
 	default:
@@ -380,6 +857,13 @@ func (l *Lexer) Lex(v *yySymType) (output int) {
 	return
 }
 
+// Error records the first error reported while scanning or parsing the line.
 func (l *Lexer) Error(e string) {
-	fmt.Fprintf(os.Stderr, "ERROR: %s\n", e)
+	if l.err == "" {
+		l.err = e
+	}
+}
+
+func syntaxError(pos ast.Pos, format string, args ...interface{}) error {
+	return &Error{Line: pos.Line, Column: pos.Column, Msg: fmt.Sprintf(format, args...)}
 }
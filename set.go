@@ -0,0 +1,123 @@
+package gohaml
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Set holds the templates of a directory tree, which are parsed once when the set is made instead
+// of every time they are loaded. A Set is a Loader, and the engines it holds have it as their
+// Loader, so that the layouts and partials they name are found in it as well. The engines are
+// shared by every render and must not be changed.
+type Set struct {
+	engines map[string]*Engine
+}
+
+// NewSet parses and compiles every .haml file in the tree rooted at dir, in parallel. The
+// templates are named by their paths relative to dir, with slashes, like "layouts/app.haml". If
+// templates contain syntax errors, err is an ErrorList holding all of them, with the File they
+// were found in, and set is nil.
+func NewSet(dir string) (set *Set, err error) {
+	var names []string
+	err = filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && filepath.Ext(p) == ".haml" {
+			rel, err := filepath.Rel(dir, p)
+			if err != nil {
+				return err
+			}
+			names = append(names, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	if err != nil {
+		return
+	}
+
+	// Every template is parsed and compiled by one of as many workers as there are CPUs, which
+	// store the engine or the errors at the index of its name.
+	engines := make([]*Engine, len(names))
+	errs := make([]error, len(names))
+	indexes := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				src, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(names[i])))
+				if err != nil {
+					errs[i] = err
+					continue
+				}
+				if engines[i], errs[i] = NewEngineAll(string(src), 0); errs[i] == nil {
+					engines[i].program(engines[i].Indentation, engines[i].Autoclose)
+				}
+			}
+		}()
+	}
+	for i := range names {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+
+	var list ErrorList
+	set = &Set{make(map[string]*Engine, len(names))}
+	for i, name := range names {
+		switch e := errs[i].(type) {
+		case nil:
+			engines[i].Loader = set
+			set.engines[name] = engines[i]
+		case ErrorList:
+			for _, se := range e {
+				se.File = name
+			}
+			list = append(list, e...)
+		default:
+			return nil, e
+		}
+	}
+	if len(list) > 0 {
+		list.Sort()
+		return nil, list
+	}
+	return
+}
+
+// Lookup returns the template of the set with the given name, adding the .haml extension if it
+// has none, or nil if there is no such template. Leading slashes are ignored.
+func (self *Set) Lookup(name string) *Engine {
+	return self.engines[strings.TrimPrefix(path.Clean("/"+templateName(name)), "/")]
+}
+
+// Load implements Loader by looking the template named by id up in the set.
+func (self *Set) Load(id interface{}) (engine *Engine, err error) {
+	name, ok := id.(string)
+	if !ok {
+		return nil, fmt.Errorf("id: %v is not a string", id)
+	}
+	if engine = self.Lookup(name); engine == nil {
+		err = fmt.Errorf("%s: no such template", name)
+	}
+	return
+}
+
+// Render writes the markup of the template name for data to w like RenderContext does.
+func (self *Set) Render(name string, w io.Writer, data interface{}) error {
+	engine := self.Lookup(name)
+	if engine == nil {
+		return fmt.Errorf("gohaml: %s: no such template", name)
+	}
+	return engine.RenderContext(context.Background(), w, data)
+}
@@ -2,14 +2,46 @@ package gohaml
 
 import (
 	"bytes"
+	"encoding"
 	"fmt"
 	"reflect"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
+
+	"github.com/realistschuckle/gohaml/ast"
 )
 
 type res struct {
 	value           string
 	needsResolution bool
+	path            []string
+	lit             interface{}
+	comp            *composite
+	pipe            *pipe
+}
+
+// composite is a slice or a map literal, whose elements are evaluated every time it is. A slice
+// literal makes a []interface{}; a map literal makes a map[string]interface{} if all of its keys
+// are string literals, or a map[interface{}]interface{}.
+type composite struct {
+	keys       []res
+	values     []res
+	isMap      bool
+	stringKeys bool
+}
+
+// pipe is the value of x passed through the helpers of stages in turn.
+type pipe struct {
+	x      res
+	stages []stage
+}
+
+// stage calls the helper name with the value before it followed by args.
+type stage struct {
+	name string
+	args []res
 }
 
 type resPair struct {
@@ -18,202 +50,395 @@ type resPair struct {
 }
 
 type inode interface {
-	parent() inode
-	indentLevel() int
-	setIndentLevel(i int)
-	addChild(n inode)
-	noNewline() bool
-	resolve(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool)
-	setParent(n inode)
-	nil() bool
-}
-
-type icodenode interface {
-	setLHS(s string)
-	parent() inode
-	indentLevel() int
-	setIndentLevel(i int)
-	addChild(n inode)
 	noNewline() bool
-	resolve(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool)
-	setParent(n inode)
-	nil() bool
 }
 
 type node struct {
-	_parent      inode
-	_remainder   res
-	_name        string
-	_attrs       []*resPair
-	_noNewline   bool
-	_autoclose   bool
-	_indentLevel int
-	_children    []inode
+	_remainder res
+	_name      string
+	_attrs     []*resPair
+	_noNewline bool
+	_autoclose bool
+	_children  []inode
 }
 
 type tree struct {
-	nodes []inode
+	nodes   []inode
+	extends string
+	mixins  map[string]*defnode
+}
+
+// newTree converts the syntax tree of a template into the nodes the engine renders. The blocks
+// at the top level of a template that extends a layout define the content of the layout's
+// blocks instead of being rendered in place.
+func newTree(f *ast.File) (output *tree) {
+	output = &tree{mixins: make(map[string]*defnode)}
+	for _, n := range f.Nodes {
+		if e, ok := n.(*ast.Extends); ok {
+			output.extends = e.Name
+		}
+	}
+	for _, n := range f.Nodes {
+		if d, ok := n.(*ast.Def); ok {
+			output.mixins[d.Name] = &defnode{_params: d.Params, _children: newNodes(d.Body)}
+		} else if b, ok := n.(*ast.Block); ok && output.extends != "" {
+			output.nodes = append(output.nodes, &definenode{_name: b.Name, _children: newNodes(b.Body)})
+		} else if node := newNode(n); node != nil {
+			output.nodes = append(output.nodes, node)
+		}
+	}
+	return
 }
 
-func newTree() (output *tree) {
-	output = &tree{}
+func newNodes(list []ast.Node) (output []inode) {
+	for _, n := range list {
+		if node := newNode(n); node != nil {
+			output = append(output, node)
+		}
+	}
 	return
 }
 
-func (self res) resolve(scope map[string]interface{}) (output string) {
-	output = self.value
-	if self.needsResolution {
-		curr := self.resolveValue(scope)
+func newNode(n ast.Node) inode {
+	switch n := n.(type) {
+	case *ast.Doctype:
+		return &node{_name: "doctype", _remainder: res{n.Type, false, nil, n.Type, nil, nil}}
+	case *ast.Tag:
+		output := &node{_name: n.Name, _noNewline: n.NoNewline, _autoclose: n.SelfClosing}
+		for _, attr := range n.Attrs {
+			output._attrs = append(output._attrs, &resPair{newRes(attr.Key), newRes(attr.Value)})
+		}
+		switch inline := n.Inline.(type) {
+		case *ast.Text:
+			output._remainder = res{inline.Text, false, nil, inline.Text, nil, nil}
+		case *ast.Script:
+			output._remainder = newRes(inline.X)
+		}
+		output._children = newNodes(n.Children)
+		return output
+	case *ast.Text:
+		return &node{_remainder: res{n.Text, false, nil, n.Text, nil, nil}, _noNewline: n.NoNewline}
+	case *ast.Script:
+		return &node{_remainder: newRes(n.X), _noNewline: n.NoNewline}
+	case *ast.Range:
+		output := &rangenode{_children: newNodes(n.Body), _lhs1: n.Key, _lhs2: n.Value, _rhs: newRes(n.X)}
+		if n.Else != nil {
+			output._else = newNodes(n.Else.Body)
+		}
+		return output
+	case *ast.Switch:
+		output := &switchnode{_x: newRes(n.X)}
+		for _, c := range n.Cases {
+			cn := casenode{_children: newNodes(c.Body), _default: c.List == nil}
+			for _, x := range c.List {
+				cn._values = append(cn._values, newRes(x))
+			}
+			output._cases = append(output._cases, cn)
+		}
+		return output
+	case *ast.Break:
+		return &branchnode{}
+	case *ast.Continue:
+		return &branchnode{_continue: true}
+	case *ast.Assign:
+		if lit, ok := n.X.(*ast.Lit); ok {
+			return &declassnode{_lhs: n.Name, _rhs: lit.Value, _define: n.Define}
+		}
+		return &vdeclassnode{_lhs: n.Name, _rhs: newRes(n.X), _define: n.Define}
+	case *ast.Block:
+		return &blocknode{_name: n.Name, _children: newNodes(n.Body)}
+	case *ast.Yield:
+		return &yieldnode{_value: newRes(&ast.Path{Names: []string{"yield"}})}
+	case *ast.ContentFor:
+		return &contentfornode{_name: n.Name, _children: newNodes(n.Body)}
+	case *ast.Content:
+		return &contentnode{_name: n.Name}
+	case *ast.Render:
+		output := &rendernode{_name: templateName(n.Name)}
+		if n.Collection != nil {
+			collection := newRes(n.Collection)
+			output._collection = &collection
+		}
+		for _, local := range n.Locals {
+			output._locals = append(output._locals, local.Name)
+			output._values = append(output._values, newRes(local.X))
+		}
+		return output
+	case *ast.Call:
+		output := &callnode{_name: n.Name, _children: newNodes(n.Body)}
+		for _, arg := range n.Args {
+			output._args = append(output._args, newRes(arg))
+		}
+		return output
+	}
+	return nil
+}
 
-	OutputSwitch:
-		switch t := curr; t.Kind() {
-		case reflect.String:
-			output = t.String()
-		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-			output = fmt.Sprint(t.Int())
-		case reflect.Float32, reflect.Float64:
-			output = fmt.Sprint(t.Float())
-		case reflect.Ptr:
-			if !t.IsNil() {
-				curr = t.Elem()
-				goto OutputSwitch
+func newRes(x ast.Expr) res {
+	switch x := x.(type) {
+	case *ast.Lit:
+		if x.Value == nil {
+			return res{}
+		}
+		return res{fmt.Sprint(x.Value), false, nil, x.Value, nil, nil}
+	case *ast.Path:
+		return res{x.String(), true, x.Names, nil, nil, nil}
+	case *ast.SliceLit:
+		c := new(composite)
+		for _, elem := range x.Elems {
+			c.values = append(c.values, newRes(elem))
+		}
+		return res{x.String(), true, nil, nil, c, nil}
+	case *ast.MapLit:
+		c := &composite{isMap: true, stringKeys: true}
+		for i, key := range x.Keys {
+			k := newRes(key)
+			if _, ok := k.lit.(string); !ok || k.needsResolution {
+				c.stringKeys = false
+			}
+			c.keys = append(c.keys, k)
+			c.values = append(c.values, newRes(x.Values[i]))
+		}
+		return res{x.String(), true, nil, nil, c, nil}
+	case *ast.Pipe:
+		p := &pipe{x: newRes(x.X)}
+		for _, s := range x.Stages {
+			st := stage{name: s.Name}
+			for _, arg := range s.Args {
+				st.args = append(st.args, newRes(arg))
 			}
-			output = ""
-		case reflect.Interface:
-			curr = t.Elem()
-			goto OutputSwitch
-		default:
-			output = fmt.Sprint(curr)
+			p.stages = append(p.stages, st)
 		}
+		return res{x.String(), true, nil, nil, nil, p}
 	}
-	return
+	return res{}
 }
 
-func (self res) resolveValue(scope map[string]interface{}) (value reflect.Value) {
-	keyPath := strings.Split(self.value, ".")
-	curr := reflect.ValueOf(scope[keyPath[0]])
-	for _, key := range keyPath[1:] {
-	TypeSwitch:
-		switch t := curr; t.Kind() {
-		case reflect.Ptr:
-			curr = t.Elem()
-			goto TypeSwitch
-		case reflect.Struct:
-			curr = t.FieldByName(key)
-		case reflect.Map:
-			curr = t.MapIndex(reflect.ValueOf(key))
+// evaluate makes the slice or the map of the literal.
+func (self *composite) evaluate(st *state) reflect.Value {
+	if !self.isMap {
+		s := make([]interface{}, len(self.values))
+		for i, v := range self.values {
+			s[i] = elem(v.resolveValue(st))
 		}
+		return reflect.ValueOf(s)
 	}
-	value = curr
-	return
+	if self.stringKeys {
+		m := make(map[string]interface{}, len(self.keys))
+		for i, k := range self.keys {
+			m[k.lit.(string)] = elem(self.values[i].resolveValue(st))
+		}
+		return reflect.ValueOf(m)
+	}
+	m := make(map[interface{}]interface{}, len(self.keys))
+	for i, k := range self.keys {
+		// Keys that cannot be compared, like slices, are left out.
+		if key := k.resolveValue(st); !key.IsValid() || key.Type().Comparable() {
+			m[elem(key)] = elem(self.values[i].resolveValue(st))
+		}
+	}
+	return reflect.ValueOf(m)
+}
+
+// stored returns the value that an assignment of r stores: the value of a slice or a map literal
+// or of a pipe, or the text of any other value.
+func (self res) stored(st *state) interface{} {
+	if self.comp != nil || self.pipe != nil {
+		return elem(self.evaluate(st))
+	}
+	return self.resolve(st)
 }
 
-func (self tree) resolve(scope map[string]interface{}, indent string, autoclose bool) (output string) {
-	//treeLen := self.nodes.Len()
-	treeLen := len(self.nodes)
-	buf := bytes.NewBuffer(make([]byte, 0))
-	for i, n := range self.nodes {
-		node := n
-		node.resolve(scope, buf, "", indent, autoclose)
-		if i != treeLen-1 && !node.noNewline() {
-			buf.WriteString("\n")
+// evaluate returns the value of r, passed through the helpers of its pipe if it has one.
+func (self res) evaluate(st *state) reflect.Value {
+	if self.pipe == nil {
+		return self.resolveValue(st)
+	}
+	v := self.pipe.x.evaluate(st)
+	for _, s := range self.pipe.stages {
+		if v = st.call(s, v); st.err != nil {
+			return reflect.Value{}
 		}
 	}
-	output = buf.String()
+	return v
+}
+
+// interfaceValue returns the value of r, or nil if it has none that can be passed on.
+func (self res) interfaceValue(st *state) interface{} {
+	if !self.needsResolution {
+		return self.lit
+	}
+	if v := self.resolveValue(st); v.IsValid() && v.CanInterface() {
+		return v.Interface()
+	}
+	return nil
+}
+
+func (self res) resolve(st *state) (output string) {
+	output = self.value
+	if self.needsResolution {
+		output = formatValue(self.evaluate(st), st.formatters)
+	}
 	return
 }
 
-func (self node) resolve(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool) {
-	remainder := self._remainder.resolve(scope)
-	if self._name == "doctype" {
-		buf.WriteString("<!DOCTYPE html")
-		switch strings.TrimSpace(self._remainder.value) {
-		case "":
-			buf.WriteString(" PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\"")
-		case "Strict":
-			buf.WriteString(" PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\"")
-		case "Frameset":
-			buf.WriteString(" PUBLIC \"-//W3C//DTD XHTML 1.0 Frameset//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd\"")
-		case "1.1":
-			buf.WriteString(" PUBLIC \"-//W3C//DTD XHTML 1.1//EN\" \"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd\"")
-		case "Basic":
-			buf.WriteString(" PUBLIC \"-//W3C//DTD XHTML Basic 1.1//EN\" \"http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd\"")
-		case "Mobile":
-			buf.WriteString(" PUBLIC \"-//WAPFORUM//DTD XHTML Mobile 1.2//EN\" \"http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd\"")
-		case "RDFa":
-			buf.WriteString(" PUBLIC \"-//W3C//DTD XHTML+RDFa 1.0//EN\" \"http://www.w3.org/MarkUp/DTD/xhtml-rdfa-1.dtd\"")
+// Format returns the text that the engine outputs for v. It is used by the code that GenerateGo
+// writes for values whose type is not known until they are rendered.
+func Format(v interface{}) string {
+	return formatValue(reflect.ValueOf(v), nil)
+}
+
+// formatValue returns the text of v. The function of formatters for the type of v, or of a
+// value that v points to, takes precedence. Otherwise times are written in RFC 3339 format,
+// errors, fmt.Stringers and encoding.TextMarshalers format themselves, byte slices are written as
+// strings and numbers with the precision of their type. Missing values and nil pointers render
+// as nothing.
+func formatValue(v reflect.Value, formatters map[reflect.Type]func(interface{}) string) string {
+	for {
+		switch v.Kind() {
+		case reflect.Invalid:
+			return ""
+		case reflect.Ptr, reflect.Interface:
+			if v.IsNil() {
+				return ""
+			}
 		}
-		buf.WriteString(">")
-	} else if len(self._attrs) > 0 && len(remainder) > 0 {
-		if len(self._name) == 0 {
-			self._name = "div"
+		if v.CanInterface() {
+			if f, ok := formatters[v.Type()]; ok {
+				return f(v.Interface())
+			}
+			switch x := v.Interface().(type) {
+			case time.Time:
+				return x.Format(time.RFC3339)
+			case error:
+				return x.Error()
+			case fmt.Stringer:
+				return x.String()
+			case encoding.TextMarshaler:
+				if text, err := x.MarshalText(); err == nil {
+					return string(text)
+				}
+			}
 		}
-		buf.WriteString("<")
-		buf.WriteString(self._name)
-		self.resolveAttrs(scope, buf)
-		buf.WriteString(">")
-		buf.WriteString(remainder)
-		buf.WriteString("</")
-		buf.WriteString(self._name)
-		buf.WriteString(">")
-		//} else if self._attrs.Len() > 0 {
-	} else if len(self._attrs) > 0 {
-		if len(self._name) == 0 {
-			self._name = "div"
+		switch v.Kind() {
+		case reflect.Ptr, reflect.Interface:
+			v = v.Elem()
+			continue
+		case reflect.String:
+			return v.String()
+		case reflect.Bool:
+			return strconv.FormatBool(v.Bool())
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.FormatInt(v.Int(), 10)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return strconv.FormatUint(v.Uint(), 10)
+		case reflect.Float32:
+			return strconv.FormatFloat(v.Float(), 'g', -1, 32)
+		case reflect.Float64:
+			return strconv.FormatFloat(v.Float(), 'g', -1, 64)
+		case reflect.Complex64:
+			return strconv.FormatComplex(v.Complex(), 'g', -1, 64)
+		case reflect.Complex128:
+			return strconv.FormatComplex(v.Complex(), 'g', -1, 128)
+		case reflect.Slice:
+			if v.Type().Elem().Kind() == reflect.Uint8 {
+				return string(v.Bytes())
+			}
 		}
-		buf.WriteString("<")
-		buf.WriteString(self._name)
-		self.resolveAttrs(scope, buf)
-		self.outputChildren(scope, buf, curIndent, indent, autoclose)
-	} else if len(self._name) > 0 && len(remainder) > 0 {
-		buf.WriteString("<")
-		buf.WriteString(self._name)
-		buf.WriteString(">")
-		buf.WriteString(remainder)
-		buf.WriteString("</")
-		buf.WriteString(self._name)
-		buf.WriteString(">")
-	} else if len(self._name) > 0 {
-		buf.WriteString("<")
-		buf.WriteString(self._name)
-		self.outputChildren(scope, buf, curIndent, indent, autoclose)
-	} else {
-		buf.WriteString(remainder)
+		return fmt.Sprint(v)
 	}
 }
 
-func (self node) outputChildren(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool) {
-	ind := curIndent + indent
-	if self._noNewline {
-		ind = curIndent
+func (self res) resolveValue(st *state) (value reflect.Value) {
+	if !self.needsResolution {
+		return reflect.ValueOf(self.lit)
 	}
-	//childLen := self._children.Len()
-	childLen := len(self._children)
-	if childLen > 0 {
-		buf.WriteString(">")
-		for i, n := range self._children {
-			//node := n.(inode)
-			node := n
-			if i != 0 || !self._noNewline {
-				buf.WriteString("\n")
-				buf.WriteString(ind)
-			}
-			node.resolve(scope, buf, ind, indent, autoclose)
+	if self.comp != nil {
+		return self.comp.evaluate(st)
+	}
+	curr := st.lookup(self.path[0])
+	for _, key := range self.path[1:] {
+		curr = member(curr, key)
+	}
+	value = curr
+	return
+}
+
+// member returns the field of the struct or the entry of the map v named key, following pointers
+// and interfaces. Maps whose keys are not strings have no entries named by key. Values of other
+// kinds are returned as they are.
+func member(v reflect.Value, key string) reflect.Value {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	switch v.Kind() {
+	case reflect.Struct:
+		if index := fieldIndex(v.Type(), key); index != nil {
+			return v.FieldByIndex(index)
 		}
-		if !self._noNewline {
-			buf.WriteString("\n")
-			buf.WriteString(curIndent)
+		return reflect.Value{}
+	case reflect.Map:
+		k := reflect.ValueOf(key)
+		switch t := v.Type().Key(); {
+		case t.Kind() == reflect.String:
+			return v.MapIndex(k.Convert(t))
+		case k.Type().AssignableTo(t):
+			return v.MapIndex(k)
 		}
-		buf.WriteString("</")
-		buf.WriteString(self._name)
-		buf.WriteString(">")
-	} else {
-		if autoclose || self._autoclose {
-			buf.WriteString(" />")
-		} else {
-			buf.WriteString(">")
+		return reflect.Value{}
+	}
+	return v
+}
+
+type fieldKey struct {
+	typ  reflect.Type
+	name string
+}
+
+// fieldIndexes caches the index sequences of the fields looked up by name, so that rendering
+// the same struct types again does not search their fields.
+var fieldIndexes = struct {
+	sync.RWMutex
+	m map[fieldKey][]int
+}{m: make(map[fieldKey][]int)}
+
+// fieldIndex returns the index sequence of the field of the struct type t with the given name,
+// or nil if t has no such field.
+func fieldIndex(t reflect.Type, name string) []int {
+	key := fieldKey{t, name}
+	fieldIndexes.RLock()
+	index, ok := fieldIndexes.m[key]
+	fieldIndexes.RUnlock()
+	if !ok {
+		if field, found := t.FieldByName(name); found {
+			index = field.Index
 		}
+		fieldIndexes.Lock()
+		fieldIndexes.m[key] = index
+		fieldIndexes.Unlock()
 	}
+	return index
+}
+
+// doctype returns the declaration written for a "!!!" line followed by t.
+func doctype(t string) string {
+	switch strings.TrimSpace(t) {
+	case "":
+		return "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Transitional//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-transitional.dtd\">"
+	case "Strict":
+		return "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Strict//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-strict.dtd\">"
+	case "Frameset":
+		return "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.0 Frameset//EN\" \"http://www.w3.org/TR/xhtml1/DTD/xhtml1-frameset.dtd\">"
+	case "1.1":
+		return "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML 1.1//EN\" \"http://www.w3.org/TR/xhtml11/DTD/xhtml11.dtd\">"
+	case "Basic":
+		return "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML Basic 1.1//EN\" \"http://www.w3.org/TR/xhtml-basic/xhtml-basic11.dtd\">"
+	case "Mobile":
+		return "<!DOCTYPE html PUBLIC \"-//WAPFORUM//DTD XHTML Mobile 1.2//EN\" \"http://www.openmobilealliance.org/tech/DTD/xhtml-mobile12.dtd\">"
+	case "RDFa":
+		return "<!DOCTYPE html PUBLIC \"-//W3C//DTD XHTML+RDFa 1.0//EN\" \"http://www.w3.org/MarkUp/DTD/xhtml-rdfa-1.dtd\">"
+	}
+	return "<!DOCTYPE html>"
 }
 
 func contains(value string, slice []string) bool {
@@ -225,13 +450,14 @@ func contains(value string, slice []string) bool {
 	return false
 }
 
-func (self node) resolveAttrs(scope map[string]interface{}, buf *bytes.Buffer) {
+// WriteAttrs writes the attributes given as alternating keys and values. Values of repeated keys
+// are joined by spaces, attributes are written in the order in which their keys first appear, an
+// attribute whose value is "false" is left out, and one whose value is "true" gets its key as
+// value. It is used by the code that GenerateGo writes.
+func WriteAttrs(buf *bytes.Buffer, pairs ...string) {
 	attrMap := make(map[string]string)
-
-	// for i := 0; i < self._attrs.Len(); i++ {
-	for _, resPair := range self._attrs {
-		//resPair := self._attrs.At(i).(*resPair)
-		key, value := resPair.key.resolve(scope), resPair.value.resolve(scope)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key, value := pairs[i], pairs[i+1]
 		if _, ok := attrMap[key]; ok {
 			attrMap[key] += " " + value
 		} else {
@@ -240,10 +466,9 @@ func (self node) resolveAttrs(scope map[string]interface{}, buf *bytes.Buffer) {
 	}
 	// don't iterate over map in order to preserve the order in which
 	// the attributes were collected.
-	//	for key, value := range attrMap {
 	var seenKeys []string
-	for _, resPair := range self._attrs {
-		key := resPair.key.resolve(scope)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		key := pairs[i]
 		if contains(key, seenKeys) {
 			continue
 		}
@@ -265,307 +490,137 @@ func (self node) resolveAttrs(scope map[string]interface{}, buf *bytes.Buffer) {
 	}
 }
 
-func (self *node) addChild(n inode) {
-	n.setParent(self)
-	//self._children.Push(n)
-	self._children = append(self._children, n)
-}
-
-func (self *node) addAttr(key string, value string) {
-	keyLookup, valueLookup := true, true
-	if key[0] == ':' {
-		keyLookup = false
-		key = key[1:]
-	}
-	if value == "true" || value == "false" {
-		valueLookup = false
-	}
-	if value[0] == '"' {
-		valueLookup = false
-		value = value[1 : len(value)-1]
-	}
-	//self._attrs.Push(&resPair{res{key, keyLookup}, res{value, valueLookup}})
-	self._attrs = append(self._attrs, &resPair{res{key, keyLookup}, res{value, valueLookup}})
-}
-
-func (self *node) addAttrNoLookup(key string, value string) {
-	//self._attrs.Push(&resPair{res{key, false}, res{value, false}})
-	self._attrs = append(self._attrs, &resPair{res{key, false}, res{value, false}})
-}
-
-func (self *node) parent() inode {
-	return self._parent
-}
-
-func (self *node) indentLevel() int {
-	return self._indentLevel
-}
-
-func (self *node) setIndentLevel(i int) {
-	self._indentLevel = i
-}
-
-func (self *node) setRemainder(value string, needsResolution bool) {
-	self._remainder = res{value, needsResolution}
-}
-
-func (self *node) setNoNewline(b bool) {
-	self._noNewline = b
-}
-
 func (self *node) noNewline() bool {
 	return self._noNewline
 }
 
-func (self *node) setParent(n inode) {
-	self._parent = n
-}
-
-func (self *node) nil() bool {
-	return self == nil
-}
-
 type rangenode struct {
-	_parent      inode
-	_indentLevel int
 	//_children    vector.Vector
 	_children []inode
+	_else     []inode
 
 	_lhs1, _lhs2 string
 	_rhs         res
 }
 
-func (self *rangenode) parent() inode {
-	return self._parent
-}
-
-func (self *rangenode) indentLevel() int {
-	return self._indentLevel
-}
-
-func (self *rangenode) setIndentLevel(i int) {
-	self._indentLevel = i
-}
-
-func (self *rangenode) addChild(n inode) {
-	n.setParent(self)
-	//self._children.Push(n)
-	self._children = append(self._children, n)
-}
-
 func (self *rangenode) noNewline() bool {
 	return false
 }
 
-func (self *rangenode) resolve(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool) {
-	//oldlhs1, oklhs1 := scope[self._lhs1]
-	//oldlhs2, oklhs2 := scope[self._lhs2]
-
-	__lhs1 := scope[self._lhs1]
-	__lhs2 := scope[self._lhs2]
-
-	value := self._rhs.resolveValue(scope)
-
-	switch t := value; t.Kind() {
-	case reflect.Slice:
-		for i := 0; i < t.Len(); i++ {
-			v := t.Index(i)
-			var iv interface{}
-
-			switch t := v; t.Kind() {
-			case reflect.String:
-				iv = t.String()
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				iv = fmt.Sprint(t.Int())
-			case reflect.Float32, reflect.Float64:
-				iv = fmt.Sprint(t.Float())
-			case reflect.Struct:
-				iv = t.Interface()
-			}
-
-			scope[self._lhs1] = i
-			scope[self._lhs2] = iv
-
-			for _, n := range self._children {
-				node := n
-				node.resolve(scope, buf, curIndent, indent, autoclose)
-				if i != t.Len()-1 && !node.noNewline() {
-					buf.WriteString("\n")
-					buf.WriteString(curIndent)
-				}
-			}
-		}
-	case reflect.Array:
-		for i := 0; i < t.Len(); i++ {
-			v := t.Index(i)
-			var iv interface{}
-
-			switch t := v; t.Kind() {
-			case reflect.String:
-				iv = t.String()
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				iv = fmt.Sprint(t.Int())
-			case reflect.Float32, reflect.Float64:
-				iv = fmt.Sprint(t.Float())
-			case reflect.Struct:
-				iv = t.Interface()
-			}
-
-			scope[self._lhs1] = i
-			scope[self._lhs2] = iv
-
-			for _, n := range self._children {
-				node := n
-				node.resolve(scope, buf, curIndent, indent, autoclose)
-				if i != t.Len()-1 && !node.noNewline() {
-					buf.WriteString("\n")
-					buf.WriteString(curIndent)
-				}
-			}
-		}
-	case reflect.Map:
-		for i, k := range t.MapKeys() {
-			v := t.MapIndex(k)
-
-			var iv interface{}
-
-			switch t := k; t.Kind() {
-			case reflect.String:
-				iv = t.String()
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				iv = fmt.Sprint(t.Int())
-			case reflect.Float32, reflect.Float64:
-				iv = fmt.Sprint(t.Float())
-			}
-
-			scope[self._lhs1] = iv
-
-			switch t := v; t.Kind() {
-			case reflect.String:
-				iv = t.String()
-			case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
-				iv = fmt.Sprint(t.Int())
-			case reflect.Float32, reflect.Float64:
-				iv = fmt.Sprint(t.Float())
-			}
-
-			scope[self._lhs2] = iv
-
-			for _, n := range self._children {
-				node := n
-				node.resolve(scope, buf, curIndent, indent, autoclose)
-				if i != t.Len()-1 && !node.noNewline() {
-					buf.WriteString("\n")
-					buf.WriteString(curIndent)
-				}
-			}
-		}
-	}
-
-	scope[self._lhs1] = __lhs1 // {oldlhs1, oklhs1}
-	scope[self._lhs2] = __lhs2 // {oldlhs2, oklhs2}
+type declassnode struct {
+	_lhs    string
+	_rhs    interface{}
+	_define bool
 }
 
-func (self *rangenode) setParent(n inode) {
-	self._parent = n
+func (self *declassnode) noNewline() bool {
+	return true
 }
 
-func (self *rangenode) nil() bool {
-	return self == nil
+type switchnode struct {
+	_x     res
+	_cases []casenode
 }
 
-type declassnode struct {
-	_parent      inode
-	_indentLevel int
-	//_children    vector.Vector
+type casenode struct {
+	_values   []res
 	_children []inode
-
-	_lhs string
-	_rhs interface{}
+	_default  bool
 }
 
-func (self *declassnode) parent() inode {
-	return self._parent
+func (self *switchnode) noNewline() bool {
+	return false
 }
 
-func (self *declassnode) indentLevel() int {
-	return self._indentLevel
+type branchnode struct {
+	_continue bool
 }
 
-func (self *declassnode) setIndentLevel(i int) {
-	self._indentLevel = i
+func (self *branchnode) noNewline() bool {
+	return true
 }
 
-func (self *declassnode) addChild(n inode) {
-	n.setParent(self)
-	//self._children.Push(n)
-	self._children = append(self._children, n)
+type vdeclassnode struct {
+	_lhs    string
+	_rhs    res
+	_define bool
 }
 
-func (self *declassnode) noNewline() bool {
-	return false
+func (self *vdeclassnode) noNewline() bool {
+	return true
 }
 
-func (self *declassnode) resolve(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool) {
-	scope[self._lhs] = self._rhs
+type blocknode struct {
+	_name     string
+	_children []inode
 }
 
-func (self *declassnode) setParent(n inode) {
-	self._parent = n
+func (self *blocknode) noNewline() bool {
+	return false
 }
 
-func (self *declassnode) nil() bool {
-	return self == nil
+// definenode is a block at the top level of a template that extends a layout. It renders
+// nothing where it stands, so it never takes a newline after it.
+type definenode struct {
+	_name     string
+	_children []inode
 }
 
-func (self *declassnode) setLHS(s string) {
-	self._lhs = s
+func (self *definenode) noNewline() bool {
+	return true
 }
 
-type vdeclassnode struct {
-	_parent      inode
-	_indentLevel int
-	//_children    vector.Vector
+// contentfornode renders its children into a slot. Like definenode it renders nothing where it
+// stands.
+type contentfornode struct {
+	_name     string
 	_children []inode
+}
 
-	_lhs string
-	_rhs res
+func (self *contentfornode) noNewline() bool {
+	return true
 }
 
-func (self *vdeclassnode) parent() inode {
-	return self._parent
+type contentnode struct {
+	_name string
 }
 
-func (self *vdeclassnode) indentLevel() int {
-	return self._indentLevel
+func (self *contentnode) noNewline() bool {
+	return false
 }
 
-func (self *vdeclassnode) setIndentLevel(i int) {
-	self._indentLevel = i
+// defnode is a mixin. It is kept apart from the nodes of the tree, which its calls refer to by
+// name.
+type defnode struct {
+	_params   []string
+	_children []inode
 }
 
-func (self *vdeclassnode) addChild(n inode) {
-	n.setParent(self)
-	//self._children.Push(n)
-	self._children = append(self._children, n)
+type callnode struct {
+	_name     string
+	_args     []res
+	_children []inode
 }
 
-func (self *vdeclassnode) noNewline() bool {
+func (self *callnode) noNewline() bool {
 	return false
 }
 
-func (self *vdeclassnode) resolve(scope map[string]interface{}, buf *bytes.Buffer, curIndent string, indent string, autoclose bool) {
-	scope[self._lhs] = self._rhs.resolve(scope)
+type rendernode struct {
+	_name       string
+	_collection *res
+	_locals     []string
+	_values     []res
 }
 
-func (self *vdeclassnode) setParent(n inode) {
-	self._parent = n
+func (self *rendernode) noNewline() bool {
+	return false
 }
 
-func (self *vdeclassnode) nil() bool {
-	return self == nil
+type yieldnode struct {
+	_value res
 }
 
-func (self *vdeclassnode) setLHS(s string) {
-	self._lhs = s
+func (self *yieldnode) noNewline() bool {
+	return false
 }